@@ -2,34 +2,46 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/rsa"
 	"errors"
-	"math/big"
 	"sort"
 	"time"
 )
 
 func NewBlock(miner string, prevHash []byte) *Block {
 	return &Block{
-		Difficulty: DIFFICULTY,
-		PrevHash:   prevHash,
-		Miner:      miner,
-		Mapping:    make(map[string]uint64),
+		BlockHeader: BlockHeader{
+			PrevHash: prevHash,
+			Miner:    miner,
+		},
+		BlockBody: BlockBody{
+			Mapping: make(map[string]uint64),
+		},
 	}
 }
 
 func (block *Block) Accept(chain *BlockChain, user *User, ch chan bool) error {
+	return block.accept(chain, user, ch, time.Now())
+}
+
+func (block *Block) accept(chain *BlockChain, user *User, ch chan bool, btime time.Time) error {
+	block.ChainID = chain.ID()
+	block.TimeStamp = btime.Format(time.RFC3339)
 	if !block.transactionsIsValid(chain) {
 		return errors.New("transactions is not valid")
 	}
-	block.AddTransaction(chain, &Transaction{
-		RandBytes: GenerateRandomBytes(RAND_BYTES),
-		Sender:    STORAGE_CHAIN,
-		Receiver:  user.Address(),
-		Value:     STORAGE_REWARD,
-	})
-	block.TimeStamp = time.Now().Format(time.RFC3339)
-	block.CurrHash = block.hash()
+	tx := &Transaction{
+		ChainID: block.ChainID,
+		Nonce:   chain.Size() + 1,
+		Sender:  STORAGE_CHAIN,
+		Outputs: []Output{{
+			Receiver: user.Address(),
+			Value:    chain.Spec.StorageReward,
+		}},
+	}
+	tx.CurrHash = tx.hash()
+	block.AddTransaction(chain, tx)
+	block.Bits = chain.NextBits(block.PrevHash)
+	block.commit()
 	block.Signature = block.sign(user.Private())
 	block.Nonce = block.proof(ch)
 	return nil
@@ -39,28 +51,46 @@ func (block *Block) AddTransaction(chain *BlockChain, tx *Transaction) error {
 	if tx == nil {
 		return errors.New("tx is null")
 	}
-	if tx.Value == 0 {
-		return errors.New("tx value = 0")
+	if !tx.outputsIsValid(chain.Spec) {
+		return errors.New("tx outputs is not valid")
 	}
-	if len(block.Transactions) == TXS_LIMIT && tx.Sender != STORAGE_CHAIN {
-		return errors.New("len tx = limit")
+	if !tx.memoIsValid(chain.Spec) {
+		return errors.New("tx data is too large")
+	}
+	if !bytes.Equal(tx.ChainID, chain.ID()) {
+		return ErrOtherChain
 	}
-	var balaceInChain uint64
-	balanceInTx := tx.Value + tx.ToStorage
-	if value, ok := block.Mapping[tx.Sender]; ok {
-		balaceInChain = value
-	} else {
-		balaceInChain = chain.Balance(tx.Sender, chain.Size())
+	if tx.Sender != STORAGE_CHAIN && tx.Nonce != block.NextNonce(chain, tx.Sender) {
+		return errors.New("tx nonce is not valid")
 	}
-	if tx.Value > START_PERCENT && tx.ToStorage != STORAGE_REWARD {
-		return errors.New("storage reward pass")
+	if tx.Sender != STORAGE_CHAIN && !tx.anchorIsValid(chain) {
+		return errors.New("tx is expired")
 	}
-	if balanceInTx > balaceInChain {
-		return errors.New("balance in tx > balance in chain")
+	if !tx.IsFinal(chain.Size()+1, time.Now()) {
+		return errors.New("tx is locked")
+	}
+	if IsHTLC(tx.Sender) && !tx.htlcIsValid() {
+		return errors.New("tx htlc spend is not valid")
+	}
+	if !tx.assetIsValid() {
+		return errors.New("tx asset is not valid")
+	}
+	if uint64(len(block.Transactions)) == chain.Spec.TxsLimit && tx.Sender != STORAGE_CHAIN {
+		return errors.New("len tx = limit")
+	}
+	accounts := tx.Accounts()
+	for _, account := range accounts {
+		if tx.Debit(account) > block.balance(chain, account) {
+			return errors.New("balance in tx > balance in chain")
+		}
+	}
+	for _, account := range accounts {
+		block.Mapping[account] = block.balance(chain, account) - tx.Debit(account) + tx.credit(account)
 	}
-	block.Mapping[tx.Sender] = balaceInChain - balanceInTx
-	block.addBalance(chain, tx.Receiver, tx.Value)
 	block.addBalance(chain, STORAGE_CHAIN, tx.ToStorage)
+	if tx.Fee != 0 {
+		block.addBalance(chain, block.Miner, tx.Fee)
+	}
 	block.Transactions = append(block.Transactions, *tx)
 	return nil
 }
@@ -69,7 +99,9 @@ func (block *Block) IsValid(chain *BlockChain) bool {
 	switch {
 	case block == nil:
 		return false
-	case block.Difficulty != DIFFICULTY:
+	case !bytes.Equal(block.ChainID, chain.ID()):
+		return false
+	case block.Bits != chain.NextBits(block.PrevHash):
 		return false
 	case !block.hashIsValid(chain, chain.Size()):
 		return false
@@ -87,39 +119,59 @@ func (block *Block) IsValid(chain *BlockChain) bool {
 	return true
 }
 
+// Следующий номер транзакции адреса с учетом транзакций блока.
+func (block *Block) NextNonce(chain *BlockChain, address string) uint64 {
+	nonce := chain.Nonce(address, chain.Size())
+	for _, tx := range block.Transactions {
+		if tx.Sender == address {
+			nonce++
+		}
+	}
+	return nonce
+}
+
 func (block *Block) addBalance(chain *BlockChain, receiver string, value uint64) {
-	var balaceInChain uint64
-	if v, ok := block.Mapping[receiver]; ok {
-		balaceInChain = v
-	} else {
-		balaceInChain = chain.Balance(receiver, chain.Size())
+	block.Mapping[receiver] = block.balance(chain, receiver) + value
+}
+
+// Баланс счета с учетом уже добавленных в блок транзакций.
+func (block *Block) balance(chain *BlockChain, account string) uint64 {
+	if value, ok := block.Mapping[account]; ok {
+		return value
 	}
-	block.Mapping[receiver] = balaceInChain + value
+	return chain.Balance(account, chain.Size())
 }
 
-func (block *Block) timeIsValid(chain *BlockChain, size uint64) bool {
-	btime, err := time.Parse(time.RFC3339, block.TimeStamp)
-	if err != nil {
+func (block *Block) headerIsValid(parent *Block, bits uint32) bool {
+	switch {
+	case block == nil || parent == nil:
 		return false
-	}
-	diff := time.Now().Sub(btime)
-	if diff < 0 {
+	case !block.BlockHeader.IsValid(&parent.BlockHeader, bits):
+		return false
+	case !block.bodyIsValid():
 		return false
 	}
-	var sblock string
-	row := chain.DB.QueryRow("SELECT Block FROM BlockChain WHERE Hash=$1",
-		Base64Encode(block.PrevHash))
-	row.Scan(&sblock)
-	lblock := DeserializeBlock(sblock)
-	if lblock == nil {
+	return true
+}
+
+func (block *Block) bodyIsValid() bool {
+	switch {
+	case !bytes.Equal(block.txRoot(), block.TxRoot):
+		return false
+	case !bytes.Equal(block.mapRoot(), block.MapRoot):
+		return false
+	case !block.mappingIsValid():
 		return false
 	}
-	ltime, err := time.Parse(time.RFC3339, lblock.TimeStamp)
-	if err == nil {
+	return true
+}
+
+func (block *Block) timeIsValid(chain *BlockChain, size uint64) bool {
+	lblock, _ := chain.BlockByHash(block.PrevHash)
+	if lblock == nil {
 		return false
 	}
-	diff = btime.Sub(ltime)
-	return diff > 0
+	return block.timeIsAfter(&lblock.BlockHeader)
 }
 
 func (block *Block) transactionsIsValid(chain *BlockChain) bool {
@@ -131,27 +183,63 @@ func (block *Block) transactionsIsValid(chain *BlockChain) bool {
 			break
 		}
 	}
-	if lentx == 0 || lentx > TXS_LIMIT+plusStorage {
+	if lentx == 0 || uint64(lentx) > chain.Spec.TxsLimit+uint64(plusStorage) {
 		return false
 	}
 	for i := 0; i < lentx-1; i++ {
 		for j := i + 1; j < lentx; j++ {
-			if bytes.Equal(block.Transactions[i].RandBytes, block.Transactions[j].RandBytes) {
-				return false
-			}
 			if block.Transactions[i].Sender == STORAGE_CHAIN &&
 				block.Transactions[j].Sender == STORAGE_CHAIN {
 				return false
 			}
 		}
 	}
+	var (
+		nonces = make(map[string]uint64)
+		fees   = uint64(0)
+	)
 	for i := 0; i < lentx; i++ {
 		tx := block.Transactions[i]
+		if !bytes.Equal(tx.ChainID, block.ChainID) {
+			return false
+		}
 		if tx.Sender == STORAGE_CHAIN {
-			if tx.Receiver != block.Miner || tx.Value != STORAGE_REWARD {
+			if len(tx.Outputs) != 1 || tx.Outputs[0].Receiver != block.Miner ||
+				tx.Outputs[0].Value != chain.Spec.StorageReward {
+				return false
+			}
+			if tx.Nonce != chain.Size()+1 || tx.Fee != 0 || tx.ToStorage != 0 {
+				return false
+			}
+			if tx.Asset != "" || tx.Token != nil || tx.LockTime != 0 ||
+				len(tx.Data) != 0 || tx.Encrypted {
+				return false
+			}
+			if !tx.hashIsValid() {
 				return false
 			}
 		} else {
+			if !tx.outputsIsValid(chain.Spec) || !tx.memoIsValid(chain.Spec) {
+				return false
+			}
+			if !tx.anchorIsValid(chain) {
+				return false
+			}
+			if !tx.IsFinal(chain.Size()+1, block.time()) {
+				return false
+			}
+			if !tx.assetIsValid() {
+				return false
+			}
+			fees += tx.Fee
+			nonce, ok := nonces[tx.Sender]
+			if !ok {
+				nonce = chain.Nonce(tx.Sender, chain.Size())
+			}
+			if tx.Nonce != nonce {
+				return false
+			}
+			nonces[tx.Sender] = nonce + 1
 			if !tx.hashIsValid() {
 				return false
 			}
@@ -159,120 +247,126 @@ func (block *Block) transactionsIsValid(chain *BlockChain) bool {
 				return false
 			}
 		}
-		if !block.balanceIsValid(chain, tx.Sender) {
-			return false
-		}
-		if !block.balanceIsValid(chain, tx.Receiver) {
-			return false
+		for _, account := range tx.Accounts() {
+			if !block.balanceIsValid(chain, account) {
+				return false
+			}
 		}
 	}
+	if fees != 0 && !block.balanceIsValid(chain, block.Miner) {
+		return false
+	}
 	return true
 }
 
+// Баланс счета в блоке должен равняться балансу в цепочке с зачислениями
+// блока за вычетом списаний. Списания сверх баланса и переполнение сумм
+// делают блок недействительным.
 func (block *Block) balanceIsValid(chain *BlockChain, address string) bool {
 	if _, ok := block.Mapping[address]; !ok {
 		return false
 	}
-	lentx := len(block.Transactions)
-	balanceInChain := chain.Balance(address, chain.Size())
-	balanceSubBlock := uint64(0)
-	balanceAddBlock := uint64(0)
-	for j := 0; j < lentx; j++ {
-		tx := block.Transactions[j]
-		if tx.Sender == address {
-			balanceSubBlock += tx.Value + tx.ToStorage
+	var (
+		balance = chain.Balance(address, chain.Size())
+		debit   = uint64(0)
+	)
+	for _, tx := range block.Transactions {
+		add := []uint64{tx.credit(address)}
+		if block.Miner == address {
+			add = append(add, tx.Fee)
+		}
+		if STORAGE_CHAIN == address {
+			add = append(add, tx.ToStorage)
 		}
-		if tx.Receiver == address {
-			balanceAddBlock += tx.Value
+		for _, num := range add {
+			if balance+num < balance {
+				return false
+			}
+			balance += num
 		}
-		if tx.Receiver == address && STORAGE_CHAIN == address {
-			balanceAddBlock += tx.ToStorage
+		if debit+tx.Debit(address) < debit {
+			return false
 		}
+		debit += tx.Debit(address)
 	}
-	if (balanceInChain + balanceAddBlock - balanceSubBlock) != block.Mapping[address] {
+	if debit > balance {
 		return false
 	}
-	return true
+	return balance-debit == block.Mapping[address]
 }
 
 func (block *Block) hash() []byte {
-	var tempHash []byte
-	for _, tx := range block.Transactions {
-		tempHash = HashSum(bytes.Join(
-			[][]byte{
-				tempHash,
-				tx.CurrHash,
-			},
-			[]byte{},
-		))
+	header := block.BlockHeader
+	header.TxRoot = block.txRoot()
+	header.MapRoot = block.mapRoot()
+	return header.hash()
+}
+
+func (block *Block) commit() {
+	block.TxRoot = block.txRoot()
+	block.MapRoot = block.mapRoot()
+	block.CurrHash = block.BlockHeader.hash()
+}
+
+func (body *BlockBody) txRoot() []byte {
+	var hashes [][]byte
+	for _, tx := range body.Transactions {
+		hashes = append(hashes, txLeaf(&tx))
+	}
+	return MerkleRoot(hashes)
+}
+
+// Корень дерева Меркла над балансами блока, отсортированными по адресу.
+func (body *BlockBody) mapRoot() []byte {
+	list := body.addresses()
+	hashes := make([][]byte, len(list))
+	for i, addr := range list {
+		hashes[i] = mapLeaf(addr, body.Mapping[addr])
 	}
+	return MerkleRoot(hashes)
+}
+
+func (body *BlockBody) addresses() []string {
 	var list []string
-	for hash := range block.Mapping {
-		list = append(list, hash)
+	for addr := range body.Mapping {
+		list = append(list, addr)
 	}
 	sort.Strings(list)
-	for _, addr := range list {
-		tempHash = HashSum(bytes.Join(
-			[][]byte{
-				tempHash,
-				[]byte(addr),
-				ToBytes(block.Mapping[addr]),
-			},
-			[]byte{},
-		))
-	}
-	return HashSum(bytes.Join(
-		[][]byte{
-			tempHash,
-			ToBytes(uint64(block.Difficulty)),
-			block.PrevHash,
-			[]byte(block.Miner),
-			[]byte(block.TimeStamp),
-		},
-		[]byte{},
-	))
+	return list
+}
+
+// Лист дерева транзакций. Хеш всей транзакции закрепляет в блоке и
+// подписи со свидетельствами, которые не входят в CurrHash.
+func txLeaf(tx *Transaction) []byte {
+	return HashSum(EncodeTX(tx))
 }
 
-func (block *Block) sign(priv *rsa.PrivateKey) []byte {
-	return Sign(priv, block.CurrHash)
+func mapLeaf(address string, balance uint64) []byte {
+	enc := newEncoder()
+	enc.writeString(address)
+	enc.writeUint(balance)
+	return HashSum(enc.bytes())
 }
 
-func (block *Block) proof(ch chan bool) uint64 {
-	return ProofOfWork(block.CurrHash, block.Difficulty, ch)
+// Номера счетов отправителей после применения блока.
+func (block *Block) nonces() map[string]uint64 {
+	nonces := make(map[string]uint64)
+	for _, tx := range block.Transactions {
+		if tx.Sender != STORAGE_CHAIN {
+			nonces[tx.Sender] = tx.Nonce + 1
+		}
+	}
+	return nonces
 }
 
 func (block *Block) hashIsValid(chain *BlockChain, size uint64) bool {
 	if !bytes.Equal(block.hash(), block.CurrHash) {
 		return false
 	}
-	var id uint64
-	row := chain.DB.QueryRow("SELECT Id FROM BlockChain WHERE Hash=$1", Base64Encode(block.PrevHash))
-	row.Scan(&id)
+	_, id := chain.BlockByHash(block.PrevHash)
 	return id == size
 }
 
-func (block *Block) signIsValid() bool {
-	return Verify(ParsePublic(block.Miner), block.CurrHash, block.Signature) == nil
-}
-
-func (block *Block) proofIsValid() bool {
-	intHash := big.NewInt(1)
-	Target := big.NewInt(1)
-	hash := HashSum(bytes.Join(
-		[][]byte{
-			block.CurrHash,
-			ToBytes(block.Nonce),
-		},
-		[]byte{},
-	))
-	intHash.SetBytes(hash)
-	Target.Lsh(Target, 256-uint(block.Difficulty))
-	if intHash.Cmp(Target) == -1 {
-		return true
-	}
-	return false
-}
-
 func (block *Block) mappingIsValid() bool {
 	for addr := range block.Mapping {
 		if addr == STORAGE_CHAIN {
@@ -280,9 +374,13 @@ func (block *Block) mappingIsValid() bool {
 		}
 		flag := false
 		for _, tx := range block.Transactions {
-			if tx.Sender == addr || tx.Receiver == addr {
+			for _, participant := range tx.Accounts() {
+				if participant == addr {
+					flag = true
+				}
+			}
+			if addr == block.Miner && tx.Fee != 0 {
 				flag = true
-				break
 			}
 		}
 		if !flag {
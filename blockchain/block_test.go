@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"math"
+	"testing"
+)
+
+// Пересчитывает балансы, корни, подпись и доказательство работы блока
+// так, как их заполнил бы майнер для измененных транзакций.
+func testReseal(chain *BlockChain, miner *User, block *Block) {
+	block.Mapping = make(map[string]uint64)
+	for _, tx := range block.Transactions {
+		for _, account := range tx.Accounts() {
+			block.Mapping[account] = chain.Balance(account, chain.Size())
+		}
+	}
+	block.Mapping[STORAGE_CHAIN] = chain.Balance(STORAGE_CHAIN, chain.Size())
+	for _, tx := range block.Transactions {
+		for _, account := range tx.Accounts() {
+			block.Mapping[account] += tx.credit(account) - tx.Debit(account)
+		}
+		block.Mapping[STORAGE_CHAIN] += tx.ToStorage
+		if tx.Fee != 0 {
+			block.Mapping[block.Miner] = block.balance(chain, block.Miner) + tx.Fee
+		}
+	}
+	block.commit()
+	block.Signature = block.sign(miner.Private())
+	block.Nonce = block.proof(make(chan bool))
+}
+
+func TestCoinbaseIsValid(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		token = &Token{Issuer: miner.Address(), Name: "coin"}
+	)
+	tests := []struct {
+		name  string
+		edit  func(tx *Transaction)
+		valid bool
+	}{
+		{"valid", func(tx *Transaction) {}, true},
+		{"asset", func(tx *Transaction) { tx.Asset = token.ID() }, false},
+		{"token", func(tx *Transaction) { tx.Asset, tx.Token = token.ID(), token }, false},
+		{"to storage", func(tx *Transaction) { tx.ToStorage = 1 }, false},
+		{"locktime", func(tx *Transaction) { tx.LockTime = 1 }, false},
+		{"data", func(tx *Transaction) { tx.Data = []byte("memo") }, false},
+		{"encrypted", func(tx *Transaction) { tx.Encrypted = true }, false},
+	}
+	for _, test := range tests {
+		chain := testChain(t, NewMemoryStore(), testSpec(user))
+		tx := NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+		block := testBlock(t, chain, miner, tx)
+		coinbase := &block.Transactions[len(block.Transactions)-1]
+		test.edit(coinbase)
+		coinbase.CurrHash = coinbase.hash()
+		testReseal(chain, miner, block)
+		if _, err := chain.AcceptBlock(block); (err == nil) != test.valid {
+			t.Errorf("%s: AcceptBlock() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+	chain := testChain(t, NewMemoryStore(), testSpec(user))
+	tx := NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+	block := testBlock(t, chain, miner, tx)
+	block.Transactions[len(block.Transactions)-1].CurrHash = HashSum(nil)
+	testReseal(chain, miner, block)
+	if _, err := chain.AcceptBlock(block); err == nil {
+		t.Error("coinbase with a wrong hash is accepted")
+	}
+}
+
+func TestBalanceIsValid(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		rich  = NewUser(512)
+	)
+	tests := []struct {
+		name     string
+		receiver *User
+		value    uint64
+		valid    bool
+	}{
+		{"valid", miner, 50, true},
+		{"overspend", miner, 200, false},
+		{"credit overflow", rich, 1, false},
+	}
+	for _, test := range tests {
+		spec := testSpec(user)
+		spec.Allocations = append(spec.Allocations, Allocation{Address: rich.Address(), Value: math.MaxUint64})
+		chain := testChain(t, NewMemoryStore(), spec)
+		tx := NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+		block := testBlock(t, chain, miner, tx)
+		tx = &block.Transactions[0]
+		tx.Outputs[0] = Output{Receiver: test.receiver.Address(), Value: test.value}
+		tx.ToStorage = spec.StorageFee(test.value)
+		tx.CurrHash = tx.hash()
+		tx.Signature = tx.sign(user.Private())
+		testReseal(chain, miner, block)
+		if _, err := chain.AcceptBlock(block); (err == nil) != test.valid {
+			t.Errorf("%s: AcceptBlock() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -1,94 +1,458 @@
 package blockchain
 
 import (
-	"database/sql"
+	"bytes"
+	"errors"
+	"math/big"
 	"os"
+	"sort"
 	"time"
+)
 
-	_ "github.com/mattn/go-sqlite3"
+var (
+	ErrBlockExists   = errors.New("block already exists")
+	ErrUnknownParent = errors.New("unknown parent")
+	ErrOtherChain    = errors.New("chain id is not valid")
+	ErrOldDatabase   = errors.New("database has an old format, create a new chain")
 )
 
-func NewChain(filename, receiver string) error {
+func NewChain(filename string, spec *ChainSpec) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	file.Close()
-	db, err := sql.Open("sqlite3", filename)
+	store, err := NewSQLiteStore(filename)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	db.Exec(CREATE_TABLE)
+	defer store.Close()
+	return InitChain(store, spec)
+}
+
+func NewMemoryChain(spec *ChainSpec) *BlockChain {
+	store := NewMemoryStore()
+	if InitChain(store, spec) != nil {
+		return nil
+	}
+	return &BlockChain{
+		Store: store,
+		Spec:  spec,
+	}
+}
+
+func InitChain(store Store, spec *ChainSpec) error {
+	return initChain(store, spec, time.Now())
+}
+
+func initChain(store Store, spec *ChainSpec, btime time.Time) error {
+	if !spec.IsValid() {
+		return errors.New("spec is not valid")
+	}
 	chain := &BlockChain{
-		DB: db,
+		Store: store,
+		Spec:  spec,
 	}
 	genesis := &Block{
-		PrevHash:  []byte(GENESIS_BLOCK),
-		Mapping:   make(map[string]uint64),
-		Miner:     receiver,
-		TimeStamp: time.Now().Format(time.RFC3339),
-	}
-	genesis.Mapping[STORAGE_CHAIN] = STORAGE_VALUE
-	genesis.Mapping[receiver] = GENESIS_REWARD
-	genesis.CurrHash = genesis.hash()
-	chain.AddBlock(genesis)
-	return nil
+		BlockHeader: BlockHeader{
+			PrevHash:  spec.Hash(),
+			Bits:      TargetBits(uint(spec.Difficulty)),
+			TimeStamp: btime.Format(time.RFC3339),
+		},
+		BlockBody: BlockBody{
+			Mapping: make(map[string]uint64),
+		},
+	}
+	if spec.StorageValue != 0 {
+		genesis.Mapping[STORAGE_CHAIN] = spec.StorageValue
+	}
+	for _, alloc := range spec.Allocations {
+		genesis.Mapping[alloc.Address] = alloc.Value
+	}
+	genesis.commit()
+	return chain.Update(func(chain *BlockChain) error {
+		if err := chain.Store.PutSpec(spec); err != nil {
+			return err
+		}
+		return chain.AddBlock(genesis)
+	})
 }
 
-func LoadChain(filename string) *BlockChain {
-	db, err := sql.Open("sqlite3", filename)
+func LoadChain(filename string) (*BlockChain, error) {
+	store, err := NewSQLiteStore(filename)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	chain := &BlockChain{
-		DB: db,
+	spec := store.Spec()
+	if spec == nil {
+		store.Close()
+		return nil, errors.New("chain spec not found")
 	}
-	return chain
+	return &BlockChain{
+		Store: store,
+		Spec:  spec,
+	}, nil
+}
+
+func (chain *BlockChain) ID() []byte {
+	genesis := chain.Block(1)
+	if genesis == nil {
+		return nil
+	}
+	return genesis.CurrHash
 }
 
 func (chain *BlockChain) Size() uint64 {
-	var size uint64
-	row := chain.DB.QueryRow("SELECT Id FROM BlockChain ORDER BY Id DESC")
-	row.Scan(&size)
-	return size
+	return chain.Store.Size()
 }
 
 func (chain *BlockChain) Balance(address string, size uint64) uint64 {
+	return chain.Store.Balance(address, size)
+}
+
+func (chain *BlockChain) Nonce(address string, size uint64) uint64 {
+	return chain.Store.Nonce(address, size)
+}
+
+// Параметры новой транзакции адреса на вершине цепочки.
+func (chain *BlockChain) TxParams(address string) *TxParams {
+	return &TxParams{
+		Spec:     chain.Spec,
+		ChainID:  chain.ID(),
+		LastHash: chain.LastHash(),
+		Nonce:    chain.Nonce(address, chain.Size()),
+	}
+}
+
+// Транзакция, ссылающаяся на блок anchor, может войти в следующий блок,
+// если anchor в основной цепочке и не старше TxWindow блоков.
+func (chain *BlockChain) AnchorIsValid(anchor []byte) bool {
+	_, height := chain.BlockByHash(anchor)
+	return height != 0 && chain.Size()-height < chain.Spec.TxWindow
+}
+
+// Первая высота основной цепочки, на которой время блока не раньше
+// btime, или следующая высота, если такого блока еще нет. Время блоков
+// возрастает, поэтому поиск двоичный.
+func (chain *BlockChain) heightAt(btime time.Time) uint64 {
+	size := chain.Size()
+	index := sort.Search(int(size), func(i int) bool {
+		block := chain.Block(uint64(i) + 1)
+		return block != nil && !block.time().Before(btime)
+	})
+	return uint64(index) + 1
+}
+
+func (chain *BlockChain) LastHash() []byte {
+	return chain.Store.LastHash()
+}
+
+func (chain *BlockChain) Block(height uint64) *Block {
+	return chain.Store.Block(height)
+}
+
+func (chain *BlockChain) BlockByHash(hash []byte) (*Block, uint64) {
+	return chain.Store.BlockByHash(hash)
+}
+
+func (chain *BlockChain) GetTransaction(hash []byte) (*Transaction, uint64) {
+	return chain.Store.Transaction(hash)
+}
+
+func (chain *BlockChain) History(address string, page uint64) []TxRecord {
+	return chain.Store.History(address, page*HISTORY_PAGE, HISTORY_PAGE)
+}
+
+func (chain *BlockChain) ProveTransaction(blockHash, txHash []byte) *MerkleProof {
+	block, _ := chain.BlockByHash(blockHash)
+	if block == nil {
+		return nil
+	}
+	var hashes [][]byte
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, txLeaf(&tx))
+	}
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.CurrHash, txHash) {
+			return NewMerkleProof(hashes, uint64(i))
+		}
+	}
+	return nil
+}
+
+// Доказывает баланс адреса корнем балансов последнего блока,
+// который его изменил.
+func (chain *BlockChain) ProveBalance(address string) *BalanceProof {
+	height := chain.Store.BalanceHeight(address, chain.Size())
+	block := chain.Block(height)
+	if block == nil {
+		return nil
+	}
 	var (
-		sblock  string
-		block   *Block
-		balance uint64
+		hashes [][]byte
+		list   = block.addresses()
 	)
-	rows, err := chain.DB.Query("SELECT Block FROM BlockChain WHERE Id <= $1 ORDER BY Id DESC", size)
-	if err != nil {
-		return balance
-	}
-	defer rows.Close()
-	for rows.Next() {
-		rows.Scan(&sblock)
-		block = DeserializeBlock(sblock)
-		if value, ok := block.Mapping[address]; ok {
-			balance = value
-			break
+	for _, addr := range list {
+		hashes = append(hashes, mapLeaf(addr, block.Mapping[addr]))
+	}
+	for i, addr := range list {
+		if addr == address {
+			return &BalanceProof{
+				Height:    height,
+				BlockHash: block.CurrHash,
+				Address:   address,
+				Balance:   block.Mapping[addr],
+				Proof:     NewMerkleProof(hashes, uint64(i)),
+			}
 		}
 	}
-	return balance
+	return nil
 }
 
-func (chain *BlockChain) LastHash() []byte {
-	var hash string
-	row := chain.DB.QueryRow("SELECT Hash FROM BlockChain ORDER BY Id DESC")
-	err := row.Scan(&hash)
-	if err != nil {
+func (chain *BlockChain) AddBlock(block *Block) error {
+	work := block.work()
+	if _, parentWork := chain.Store.LoadBlock(block.PrevHash); parentWork != nil {
+		work.Add(work, parentWork)
+	}
+	return chain.putBlock(block, work)
+}
+
+func (chain *BlockChain) AcceptBlock(block *Block) ([]Transaction, error) {
+	if block == nil {
+		return nil, errors.New("block is null")
+	}
+	if !bytes.Equal(block.ChainID, chain.ID()) {
+		return nil, ErrOtherChain
+	}
+	if known, _ := chain.Store.LoadBlock(block.CurrHash); known != nil {
+		return nil, ErrBlockExists
+	}
+	parent, parentWork := chain.Store.LoadBlock(block.PrevHash)
+	if parent == nil {
+		return nil, ErrUnknownParent
+	}
+	if !block.headerIsValid(parent, chain.NextBits(block.PrevHash)) {
+		return nil, errors.New("block is not valid")
+	}
+	work := new(big.Int).Add(parentWork, block.work())
+	if bytes.Equal(block.PrevHash, chain.LastHash()) {
+		if !block.IsValid(chain) {
+			return nil, errors.New("block is not valid")
+		}
+		return nil, chain.putBlock(block, work)
+	}
+	if err := chain.Store.SaveBlock(block, work); err != nil {
+		return nil, err
+	}
+	_, tipWork := chain.Store.LoadBlock(chain.LastHash())
+	if tipWork != nil && work.Cmp(tipWork) <= 0 {
+		return nil, nil
+	}
+	var (
+		txs     []Transaction
+		invalid []byte
+	)
+	err := chain.Update(func(chain *BlockChain) error {
+		var err error
+		txs, invalid, err = chain.reorganize(block)
+		return err
+	})
+	if invalid != nil {
+		chain.Store.RemoveBlock(invalid)
+	}
+	return txs, err
+}
+
+// Применяет полученные от узла блоки в одной транзакции хранилища:
+// либо все блоки приняты, либо цепочка остается прежней.
+func (chain *BlockChain) AcceptBlocks(blocks []*Block) ([]Transaction, error) {
+	var txs []Transaction
+	err := chain.Update(func(chain *BlockChain) error {
+		var dropped []Transaction
+		for _, block := range blocks {
+			list, err := chain.AcceptBlock(block)
+			if err == ErrBlockExists {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			dropped = append(dropped, list...)
+		}
+		txs = nil
+		for _, tx := range dropped {
+			if found, _ := chain.GetTransaction(tx.CurrHash); found == nil {
+				txs = append(txs, tx)
+			}
+		}
+		return nil
+	})
+	return txs, err
+}
+
+func (chain *BlockChain) Update(fn func(chain *BlockChain) error) error {
+	return chain.Store.Update(func(store Store) error {
+		return fn(&BlockChain{
+			Store: store,
+			Spec:  chain.Spec,
+		})
+	})
+}
+
+func (chain *BlockChain) Locator() [][]byte {
+	return locator(chain.Size(), func(height uint64) []byte {
+		block := chain.Block(height)
+		if block == nil {
+			return nil
+		}
+		return block.CurrHash
+	})
+}
+
+// Проверяет, что заголовки продолжают известный блок, связаны между собой,
+// подписаны и доказаны работой. Тела блоков для этого не нужны. Работа
+// ветки не сравнивается с вершиной: ветка загружается пачками, и первые
+// пачки боковой ветки легче основной цепочки.
+func (chain *BlockChain) CheckHeaders(headers []*BlockHeader) bool {
+	if len(headers) == 0 || headers[0] == nil {
+		return false
+	}
+	parent, _ := chain.Store.LoadBlock(headers[0].PrevHash)
+	if parent == nil {
+		return false
+	}
+	var (
+		prev    = &parent.BlockHeader
+		checked = make(map[string]*BlockHeader)
+	)
+	ancestor := func(hash []byte) *BlockHeader {
+		if header, ok := checked[Base64Encode(hash)]; ok {
+			return header
+		}
+		return chain.header(hash)
+	}
+	for _, header := range headers {
+		if !header.IsValid(prev, chain.Spec.nextBits(prev, ancestor)) {
+			return false
+		}
+		checked[Base64Encode(header.CurrHash)] = header
+		prev = header
+	}
+	return true
+}
+
+// Цель для блока, продолжающего известный блок prevHash.
+func (chain *BlockChain) NextBits(prevHash []byte) uint32 {
+	return chain.Spec.nextBits(chain.header(prevHash), chain.header)
+}
+
+func (chain *BlockChain) FindFork(locator [][]byte) uint64 {
+	for _, hash := range locator {
+		if _, height := chain.BlockByHash(hash); height != 0 {
+			return height
+		}
+	}
+	return 0
+}
+
+func (chain *BlockChain) Close() error {
+	return chain.Store.Close()
+}
+
+func (chain *BlockChain) header(hash []byte) *BlockHeader {
+	block, _ := chain.Store.LoadBlock(hash)
+	if block == nil {
 		return nil
 	}
-	return Base64Decode(hash)
+	return &block.BlockHeader
+}
+
+func (chain *BlockChain) putBlock(block *Block, work *big.Int) error {
+	if err := chain.Store.SaveBlock(block, work); err != nil {
+		return err
+	}
+	return chain.Store.PutBlock(block)
+}
+
+// Откатывает основную цепочку до общего предка с веткой tip и
+// применяет блоки ветки. Возвращает транзакции отключенных блоков,
+// которые не вошли в новую ветку.
+func (chain *BlockChain) reorganize(tip *Block) ([]Transaction, []byte, error) {
+	var (
+		branch []*Block
+		fork   uint64
+	)
+	for block := tip; ; {
+		branch = append(branch, block)
+		if _, height := chain.BlockByHash(block.PrevHash); height != 0 {
+			fork = height
+			break
+		}
+		block, _ = chain.Store.LoadBlock(block.PrevHash)
+		if block == nil {
+			return nil, nil, ErrUnknownParent
+		}
+	}
+	var (
+		old  []*Block
+		size = chain.Size()
+	)
+	for i := fork + 1; i <= size; i++ {
+		old = append(old, chain.Block(i))
+	}
+	if err := chain.Store.Truncate(fork); err != nil {
+		return nil, nil, err
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if !branch[i].IsValid(chain) {
+			return nil, branch[i].CurrHash, errors.New("branch is not valid")
+		}
+		if err := chain.Store.PutBlock(branch[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	included := make(map[string]bool)
+	for _, block := range branch {
+		for _, tx := range block.Transactions {
+			included[Base64Encode(tx.CurrHash)] = true
+		}
+	}
+	var txs []Transaction
+	for _, block := range old {
+		for _, tx := range block.Transactions {
+			if tx.Sender == STORAGE_CHAIN || included[Base64Encode(tx.CurrHash)] {
+				continue
+			}
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil, nil
 }
 
-func (chain *BlockChain) AddBlock(block *Block) {
-	chain.DB.Exec("INSERT INTO BlockChain (Hash, Block) VALUES ($1, $2)",
-		Base64Encode(block.CurrHash),
-		SerializeBlock(block),
+// Хеши цепочки от вершины к генезису: сначала подряд,
+// затем с удваивающимся шагом.
+func locator(size uint64, hash func(height uint64) []byte) [][]byte {
+	var (
+		locator [][]byte
+		step    = uint64(1)
 	)
+	for height := size; height > 0; {
+		curr := hash(height)
+		if curr == nil {
+			break
+		}
+		locator = append(locator, curr)
+		if height == 1 {
+			break
+		}
+		if len(locator) >= LOCATOR_DENSE {
+			step *= 2
+		}
+		if height <= step {
+			height = 1
+		} else {
+			height -= step
+		}
+	}
+	return locator
 }
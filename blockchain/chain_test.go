@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Генезис тестовых цепочек в прошлом, чтобы блоки с шагом в минуту
+// не опережали текущее время. Одинаковый для всех цепочек теста.
+var testGenesis = time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+
+// Спецификация с минимальной сложностью и начальными балансами users.
+func testSpec(users ...*User) *ChainSpec {
+	spec := DefaultSpec("")
+	spec.Difficulty = 1
+	spec.MinDifficulty = 1
+	for _, user := range users {
+		spec.Allocations = append(spec.Allocations, Allocation{
+			Address: user.Address(),
+			Value:   100,
+		})
+	}
+	return spec
+}
+
+func testChain(t *testing.T, store Store, spec *ChainSpec) *BlockChain {
+	t.Helper()
+	if err := initChain(store, spec, testGenesis); err != nil {
+		t.Fatal(err)
+	}
+	return &BlockChain{
+		Store: store,
+		Spec:  spec,
+	}
+}
+
+// Блок над вершиной цепочки на минуту позже родителя.
+func testBlock(t *testing.T, chain *BlockChain, miner *User, txs ...*Transaction) *Block {
+	t.Helper()
+	parent := chain.Block(chain.Size())
+	block := NewBlock(miner.Address(), parent.CurrHash)
+	for _, tx := range txs {
+		if err := block.AddTransaction(chain, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := block.accept(chain, miner, make(chan bool), parent.time().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// Добывает блок и принимает его в цепочку.
+func testMine(t *testing.T, chain *BlockChain, miner *User, txs ...*Transaction) *Block {
+	t.Helper()
+	block := testBlock(t, chain, miner, txs...)
+	if _, err := chain.AcceptBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// Принимает ли цепочка блок. Принятый блок откатывается, чтобы
+// проверки не меняли цепочку.
+func testAccepts(chain *BlockChain, block *Block) error {
+	var accepted error
+	chain.Update(func(chain *BlockChain) error {
+		if _, accepted = chain.AcceptBlock(block); accepted == nil {
+			return errors.New("rollback")
+		}
+		return nil
+	})
+	return accepted
+}
+
+func TestMine(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		chain = testChain(t, NewMemoryStore(), testSpec(user))
+	)
+	for i := uint64(1); i <= 3; i++ {
+		tx := NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 1)
+		testMine(t, chain, miner, tx)
+		if size := chain.Size(); size != i+1 {
+			t.Fatalf("Size() = %d, want %d", size, i+1)
+		}
+		if nonce := chain.Nonce(user.Address(), chain.Size()); nonce != i {
+			t.Errorf("Nonce() = %d, want %d", nonce, i)
+		}
+	}
+	if balance := chain.Balance(user.Address(), chain.Size()); balance != 94 {
+		t.Errorf("user balance = %d, want 94", balance)
+	}
+	if balance := chain.Balance(miner.Address(), chain.Size()); balance != 9 {
+		t.Errorf("miner balance = %d, want 9", balance)
+	}
+}
+
+func TestReorganize(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			testReorganize(t, store)
+		})
+	}
+}
+
+// Ветка с большей работой заменяет основную цепочку вместе с балансами,
+// номерами и индексом транзакций.
+func testReorganize(t *testing.T, store Store) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		alice = NewUser(512)
+		bob   = NewUser(512)
+		spec  = testSpec(user)
+		main  = testChain(t, store, spec)
+		side  = testChain(t, NewMemoryStore(), spec)
+	)
+	if !bytes.Equal(main.ID(), side.ID()) {
+		t.Fatal("chains have different genesis blocks")
+	}
+	txA := NewTransaction(user, main.TxParams(user.Address()), []Output{{Receiver: alice.Address(), Value: 5}}, 0)
+	testMine(t, main, miner, txA)
+	var (
+		txB1   = NewTransaction(user, side.TxParams(user.Address()), []Output{{Receiver: bob.Address(), Value: 7}}, 0)
+		block1 = testMine(t, side, miner, txB1)
+		txB2   = NewTransaction(user, side.TxParams(user.Address()), []Output{{Receiver: bob.Address(), Value: 1}}, 0)
+		block2 = testMine(t, side, miner, txB2)
+	)
+	if txs, err := main.AcceptBlock(block1); err != nil || txs != nil {
+		t.Fatalf("AcceptBlock(side 1) = %v, %v", txs, err)
+	}
+	if bytes.Equal(main.LastHash(), block1.CurrHash) {
+		t.Fatal("branch with equal work replaced the main chain")
+	}
+	txs, err := main.AcceptBlock(block2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || !bytes.Equal(txs[0].CurrHash, txA.CurrHash) {
+		t.Errorf("AcceptBlock(side 2) returned %d transactions, want the dropped one", len(txs))
+	}
+	if !bytes.Equal(main.LastHash(), block2.CurrHash) || main.Size() != 3 {
+		t.Fatal("chain is not reorganized to the branch with more work")
+	}
+	size := main.Size()
+	if balance := main.Balance(alice.Address(), size); balance != 0 {
+		t.Errorf("balance of the dropped receiver = %d, want 0", balance)
+	}
+	if balance := main.Balance(bob.Address(), size); balance != 8 {
+		t.Errorf("balance of the branch receiver = %d, want 8", balance)
+	}
+	if balance := main.Balance(user.Address(), size); balance != 92 {
+		t.Errorf("sender balance = %d, want 92", balance)
+	}
+	if nonce := main.Nonce(user.Address(), size); nonce != 2 {
+		t.Errorf("sender nonce = %d, want 2", nonce)
+	}
+	if tx, _ := main.GetTransaction(txA.CurrHash); tx != nil {
+		t.Error("dropped transaction is still indexed")
+	}
+	if _, height := main.GetTransaction(txB1.CurrHash); height != 2 {
+		t.Errorf("branch transaction height = %d, want 2", height)
+	}
+	if records := main.History(alice.Address(), 0); len(records) != 0 {
+		t.Errorf("history of the dropped receiver has %d records", len(records))
+	}
+	if records := main.History(user.Address(), 0); len(records) != 2 {
+		t.Errorf("sender history has %d records, want 2", len(records))
+	}
+	again := NewTransaction(user, main.TxParams(user.Address()), []Output{{Receiver: alice.Address(), Value: 5}}, 0)
+	testMine(t, main, miner, again)
+	if balance := main.Balance(alice.Address(), main.Size()); balance != 5 {
+		t.Errorf("balance after mining on the new tip = %d, want 5", balance)
+	}
+}
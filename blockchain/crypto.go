@@ -3,13 +3,18 @@ package blockchain
 import (
 	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"math"
 	"math/big"
 	mrand "math/rand"
@@ -49,14 +54,79 @@ func Verify(pub *rsa.PublicKey, data, sign []byte) error {
 	return rsa.VerifyPSS(pub, crypto.SHA256, data, sign, nil)
 }
 
-func ProofOfWork(blockHash []byte, difficulty uint8, ch chan bool) uint64 {
+// Шифрует memo для владельца ключа pub: случайный ключ AES-GCM
+// шифруется RSA-OAEP. Формат: зашифрованный ключ длиной в модуль RSA,
+// nonce GCM, шифротекст. Транзакция помечает такое memo полем Encrypted.
+func EncryptMemo(pub *rsa.PublicKey, memo []byte) ([]byte, error) {
+	key := GenerateRandomBytes(16)
+	if key == nil {
+		return nil, errors.New("rand error")
+	}
+	encKey, err := rsa.EncryptOAEP(memoHash(pub.Size()), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := GenerateRandomBytes(uint(gcm.NonceSize()))
+	if nonce == nil {
+		return nil, errors.New("rand error")
+	}
+	data := append(encKey, nonce...)
+	return gcm.Seal(data, nonce, memo, nil), nil
+}
+
+func DecryptMemo(priv *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if len(data) < priv.Size() {
+		return nil, errors.New("memo is too short")
+	}
+	key, err := rsa.DecryptOAEP(memoHash(priv.Size()), rand.Reader, priv, data[:priv.Size()], nil)
+	if err != nil {
+		return nil, err
+	}
+	data = data[priv.Size():]
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("memo is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// Сколько байт шифрование добавляет к memo при ключе размером keySize
+// бит: зашифрованный ключ, nonce и тег GCM.
+func MemoOverhead(keySize uint64) uint64 {
+	return (keySize+7)/8 + 12 + 16
+}
+
+// Хеш OAEP для модуля размером size байт. С SHA-256 ключ AES
+// помещается только в модуль от 82 байт, поэтому для меньших ключей,
+// в том числе 512-битных по умолчанию, используется SHA-1.
+func memoHash(size int) hash.Hash {
+	if size >= 2*sha256.Size+2+16 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func ProofOfWork(blockHash []byte, Target *big.Int, ch chan bool) uint64 {
 	var (
-		Target  = big.NewInt(1)
 		intHash = big.NewInt(1)
 		nonce   = uint64(mrand.Intn(math.MaxUint32))
 		hash    []byte
 	)
-	Target.Lsh(Target, 256-uint(difficulty))
 	for nonce < math.MaxUint64 {
 		select {
 		case <-ch:
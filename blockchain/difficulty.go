@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"math/big"
+	"time"
+)
+
+// Компактная запись цели: старший байт - длина числа в байтах,
+// младшие три байта - его старшие байты. Бит 0x00800000 - знак,
+// отрицательные цели недопустимы.
+func CompactToBig(bits uint32) *big.Int {
+	var (
+		mantissa = int64(bits & 0x007fffff)
+		exponent = uint(bits >> 24)
+	)
+	if bits&0x00800000 != 0 {
+		return big.NewInt(0)
+	}
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+	return new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+}
+
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	var (
+		exponent = uint(len(target.Bytes()))
+		mantissa uint64
+	)
+	if exponent <= 3 {
+		mantissa = target.Uint64() << (8 * (3 - exponent))
+	} else {
+		mantissa = new(big.Int).Rsh(target, 8*(exponent-3)).Uint64()
+	}
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | uint32(mantissa)
+}
+
+// Цель с заданным числом ведущих нулевых бит.
+func TargetBits(zeros uint) uint32 {
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-zeros))
+}
+
+// Цель блока, следующего за parent: средняя цель последних
+// RetargetWindow блоков, умноженная на отношение их фактического
+// времени к ожидаемому. Блоки ищутся через ancestor, поэтому расчет
+// одинаков для основной цепочки, боковых веток и цепочки заголовков.
+func (spec *ChainSpec) nextBits(parent *BlockHeader, ancestor func(hash []byte) *BlockHeader) uint32 {
+	if parent == nil {
+		return 0
+	}
+	var (
+		sum    = big.NewInt(0)
+		count  = int64(0)
+		first  = parent
+		window = int64(spec.RetargetWindow)
+		limit  = int64(spec.RetargetLimit)
+	)
+	for count < window {
+		prev := ancestor(first.PrevHash)
+		if prev == nil {
+			break
+		}
+		sum.Add(sum, first.target())
+		count++
+		first = prev
+	}
+	if count == 0 {
+		return parent.Bits
+	}
+	var (
+		span     = int64(parent.time().Sub(first.time()) / time.Second)
+		expected = count * int64(spec.BlockInterval)
+	)
+	if span < expected/limit {
+		span = expected / limit
+	}
+	if span > expected*limit {
+		span = expected * limit
+	}
+	if span == 0 {
+		span = 1
+	}
+	target := sum.Div(sum, big.NewInt(count))
+	target.Mul(target, big.NewInt(span))
+	target.Div(target, big.NewInt(expected))
+	if easiest := CompactToBig(TargetBits(uint(spec.MinDifficulty))); target.Cmp(easiest) > 0 {
+		target = easiest
+	}
+	return BigToCompact(target)
+}
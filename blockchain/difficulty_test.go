@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target *big.Int
+	}{
+		{0x00000000, big.NewInt(0)},
+		{0x01123456, big.NewInt(0x12)},
+		{0x02123456, big.NewInt(0x1234)},
+		{0x03123456, big.NewInt(0x123456)},
+		{0x04123456, big.NewInt(0x12345600)},
+		{0x04923456, big.NewInt(0)},
+		{0x1d00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 208)},
+	}
+	for _, test := range tests {
+		if target := CompactToBig(test.bits); target.Cmp(test.target) != 0 {
+			t.Errorf("CompactToBig(%#08x) = %x, want %x", test.bits, target, test.target)
+		}
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	tests := []struct {
+		target *big.Int
+		bits   uint32
+	}{
+		{big.NewInt(0), 0x00000000},
+		{big.NewInt(-1), 0x00000000},
+		{big.NewInt(0x7f), 0x017f0000},
+		{big.NewInt(0x80), 0x02008000},
+		{big.NewInt(0x123456), 0x03123456},
+		{big.NewInt(0x12345678), 0x04123456},
+		{new(big.Int).Lsh(big.NewInt(0xffff), 208), 0x1d00ffff},
+		{new(big.Int).Lsh(big.NewInt(1), 255), 0x21008000},
+	}
+	for _, test := range tests {
+		if bits := BigToCompact(test.target); bits != test.bits {
+			t.Errorf("BigToCompact(%x) = %#08x, want %#08x", test.target, bits, test.bits)
+		}
+	}
+}
+
+func TestTargetBits(t *testing.T) {
+	for zeros := uint(0); zeros < 256; zeros++ {
+		var (
+			bits   = TargetBits(zeros)
+			target = CompactToBig(bits)
+		)
+		if want := new(big.Int).Lsh(big.NewInt(1), 256-zeros); target.Cmp(want) != 0 {
+			t.Errorf("TargetBits(%d) = %x, want %x", zeros, target, want)
+		}
+		if BigToCompact(target) != bits {
+			t.Errorf("TargetBits(%d): %#08x does not round trip", zeros, bits)
+		}
+	}
+}
+
+// Цепочка из count заголовков с целью bits и интервалом spacing секунд.
+// Возвращает последний заголовок и поиск предка по хешу.
+func testHeaders(count int, spacing int, bits uint32) (*BlockHeader, func(hash []byte) *BlockHeader) {
+	var (
+		headers = make(map[string]*BlockHeader)
+		start   = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		last    *BlockHeader
+	)
+	for i := 0; i < count; i++ {
+		header := &BlockHeader{
+			CurrHash:  []byte{byte(i)},
+			Bits:      bits,
+			TimeStamp: start.Add(time.Duration(i*spacing) * time.Second).Format(time.RFC3339),
+		}
+		if last != nil {
+			header.PrevHash = last.CurrHash
+		}
+		headers[string(header.CurrHash)] = header
+		last = header
+	}
+	return last, func(hash []byte) *BlockHeader {
+		return headers[string(hash)]
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	spec := DefaultSpec("")
+	spec.BlockInterval = 20
+	spec.RetargetWindow = 10
+	spec.RetargetLimit = 4
+	spec.MinDifficulty = 8
+	tests := []struct {
+		name    string
+		count   int
+		spacing int
+		zeros   uint
+		want    uint
+	}{
+		{"genesis", 1, 20, 20, 20},
+		{"on time", 20, 20, 20, 20},
+		{"slow", 20, 40, 20, 19},
+		{"fast", 20, 10, 20, 21},
+		{"slow limit", 20, 1000, 20, 18},
+		{"fast limit", 20, 0, 20, 22},
+		{"short window", 4, 40, 20, 19},
+		{"easiest", 20, 1000, 9, 8},
+	}
+	for _, test := range tests {
+		parent, ancestor := testHeaders(test.count, test.spacing, TargetBits(test.zeros))
+		if bits := spec.nextBits(parent, ancestor); bits != TargetBits(test.want) {
+			t.Errorf("%s: nextBits() = %#08x, want %#08x", test.name, bits, TargetBits(test.want))
+		}
+	}
+	if bits := spec.nextBits(nil, nil); bits != 0 {
+		t.Errorf("nextBits(nil) = %#08x, want 0", bits)
+	}
+}
@@ -0,0 +1,578 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Каноническое двоичное кодирование: байт версии, затем поля в
+// фиксированном порядке. Числа занимают 8 байт (big-endian), байтовые
+// строки и списки предваряются длиной, ключи Mapping сортируются.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func newEncoder() *encoder {
+	enc := new(encoder)
+	enc.buf.WriteByte(ENCODING_VERSION)
+	return enc
+}
+
+func (enc *encoder) bytes() []byte {
+	return enc.buf.Bytes()
+}
+
+func (enc *encoder) writeUint(num uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], num)
+	enc.buf.Write(data[:])
+}
+
+func (enc *encoder) writeBytes(data []byte) {
+	enc.writeUint(uint64(len(data)))
+	enc.buf.Write(data)
+}
+
+func (enc *encoder) writeString(data string) {
+	enc.writeBytes([]byte(data))
+}
+
+func newDecoder(data []byte) *decoder {
+	dec := &decoder{
+		data: data,
+	}
+	if len(data) == 0 || data[0] != ENCODING_VERSION {
+		dec.err = errors.New("unknown encoding version")
+		return dec
+	}
+	dec.data = data[1:]
+	return dec
+}
+
+func (dec *decoder) readUint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	if len(dec.data) < 8 {
+		dec.err = errors.New("unexpected end of data")
+		return 0
+	}
+	num := binary.BigEndian.Uint64(dec.data[:8])
+	dec.data = dec.data[8:]
+	return num
+}
+
+func (dec *decoder) readBytes() []byte {
+	size := dec.readUint()
+	if dec.err != nil {
+		return nil
+	}
+	if size > uint64(len(dec.data)) {
+		dec.err = errors.New("unexpected end of data")
+		return nil
+	}
+	if size == 0 {
+		return nil
+	}
+	data := make([]byte, size)
+	copy(data, dec.data[:size])
+	dec.data = dec.data[size:]
+	return data
+}
+
+func (dec *decoder) readString() string {
+	return string(dec.readBytes())
+}
+
+// Длина списка не может превышать число оставшихся байт,
+// поэтому поврежденные данные не приводят к большим выделениям памяти.
+func (dec *decoder) readCount() uint64 {
+	count := dec.readUint()
+	if dec.err == nil && count > uint64(len(dec.data)) {
+		dec.err = errors.New("count is not valid")
+		return 0
+	}
+	return count
+}
+
+func (dec *decoder) finish() error {
+	if dec.err == nil && len(dec.data) != 0 {
+		dec.err = errors.New("trailing data")
+	}
+	return dec.err
+}
+
+func EncodeTX(tx *Transaction) []byte {
+	enc := newEncoder()
+	tx.encode(enc)
+	return enc.bytes()
+}
+
+func DecodeTX(data []byte) *Transaction {
+	dec := newDecoder(data)
+	tx := decodeTX(dec)
+	if dec.finish() != nil {
+		return nil
+	}
+	return tx
+}
+
+func EncodeHeader(header *BlockHeader) []byte {
+	enc := newEncoder()
+	header.encode(enc)
+	return enc.bytes()
+}
+
+func DecodeHeader(data []byte) *BlockHeader {
+	dec := newDecoder(data)
+	header := decodeHeader(dec)
+	if dec.finish() != nil {
+		return nil
+	}
+	return header
+}
+
+func EncodeBody(body *BlockBody) []byte {
+	enc := newEncoder()
+	body.encode(enc)
+	return enc.bytes()
+}
+
+func DecodeBody(data []byte) *BlockBody {
+	dec := newDecoder(data)
+	body := decodeBody(dec)
+	if dec.finish() != nil {
+		return nil
+	}
+	return body
+}
+
+func EncodeBlock(block *Block) []byte {
+	enc := newEncoder()
+	block.BlockHeader.encode(enc)
+	block.BlockBody.encode(enc)
+	return enc.bytes()
+}
+
+func DecodeBlock(data []byte) *Block {
+	dec := newDecoder(data)
+	block := &Block{
+		BlockHeader: *decodeHeader(dec),
+		BlockBody:   *decodeBody(dec),
+	}
+	if dec.finish() != nil {
+		return nil
+	}
+	return block
+}
+
+func EncodeRecords(records []TxRecord) []byte {
+	enc := newEncoder()
+	enc.writeUint(uint64(len(records)))
+	for _, record := range records {
+		enc.writeUint(record.Height)
+		record.Transaction.encode(enc)
+	}
+	return enc.bytes()
+}
+
+func DecodeRecords(data []byte) []TxRecord {
+	var (
+		dec     = newDecoder(data)
+		count   = dec.readCount()
+		records []TxRecord
+	)
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		height := dec.readUint()
+		records = append(records, TxRecord{
+			Height:      height,
+			Transaction: *decodeTX(dec),
+		})
+	}
+	if dec.finish() != nil {
+		return nil
+	}
+	return records
+}
+
+func EncodeProof(proof *MerkleProof) []byte {
+	enc := newEncoder()
+	proof.encode(enc)
+	return enc.bytes()
+}
+
+func DecodeProof(data []byte) *MerkleProof {
+	dec := newDecoder(data)
+	proof := decodeProof(dec)
+	if dec.finish() != nil {
+		return nil
+	}
+	return proof
+}
+
+func EncodeBalanceProof(proof *BalanceProof) []byte {
+	enc := newEncoder()
+	enc.writeUint(proof.Height)
+	enc.writeBytes(proof.BlockHash)
+	enc.writeString(proof.Address)
+	enc.writeUint(proof.Balance)
+	if proof.Proof == nil {
+		new(MerkleProof).encode(enc)
+	} else {
+		proof.Proof.encode(enc)
+	}
+	return enc.bytes()
+}
+
+func DecodeBalanceProof(data []byte) *BalanceProof {
+	dec := newDecoder(data)
+	proof := &BalanceProof{
+		Height:    dec.readUint(),
+		BlockHash: dec.readBytes(),
+		Address:   dec.readString(),
+		Balance:   dec.readUint(),
+		Proof:     decodeProof(dec),
+	}
+	if dec.finish() != nil {
+		return nil
+	}
+	return proof
+}
+
+func EncodeSpec(spec *ChainSpec) []byte {
+	enc := newEncoder()
+	enc.writeString(spec.Name)
+	for _, num := range []uint64{
+		spec.KeySize,
+		spec.TxsLimit,
+		spec.Difficulty,
+		spec.MinDifficulty,
+		spec.BlockInterval,
+		spec.RetargetWindow,
+		spec.RetargetLimit,
+		spec.StartPercent,
+		spec.StorageReward,
+		spec.StorageValue,
+		spec.TxWindow,
+		spec.OutputsLimit,
+	} {
+		enc.writeUint(num)
+	}
+	enc.writeUint(uint64(len(spec.Allocations)))
+	for _, alloc := range spec.Allocations {
+		enc.writeString(alloc.Address)
+		enc.writeUint(alloc.Value)
+	}
+	return enc.bytes()
+}
+
+func DecodeSpec(data []byte) *ChainSpec {
+	dec := newDecoder(data)
+	spec := &ChainSpec{
+		Name: dec.readString(),
+	}
+	for _, num := range []*uint64{
+		&spec.KeySize,
+		&spec.TxsLimit,
+		&spec.Difficulty,
+		&spec.MinDifficulty,
+		&spec.BlockInterval,
+		&spec.RetargetWindow,
+		&spec.RetargetLimit,
+		&spec.StartPercent,
+		&spec.StorageReward,
+		&spec.StorageValue,
+		&spec.TxWindow,
+		&spec.OutputsLimit,
+	} {
+		*num = dec.readUint()
+	}
+	count := dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		spec.Allocations = append(spec.Allocations, Allocation{
+			Address: dec.readString(),
+			Value:   dec.readUint(),
+		})
+	}
+	if dec.finish() != nil {
+		return nil
+	}
+	return spec
+}
+
+func EncodePolicy(policy *Policy) []byte {
+	enc := newEncoder()
+	policy.encode(enc)
+	return enc.bytes()
+}
+
+func EncodeContract(contract *Contract) []byte {
+	enc := newEncoder()
+	contract.encode(enc)
+	return enc.bytes()
+}
+
+func EncodeToken(token *Token) []byte {
+	enc := newEncoder()
+	token.encode(enc)
+	return enc.bytes()
+}
+
+// Поля транзакции, которые покрываются хешем и подписью.
+func (tx *Transaction) encodeSigned(enc *encoder) {
+	enc.writeBytes(tx.ChainID)
+	enc.writeUint(tx.Nonce)
+	enc.writeBytes(tx.PrevBlock)
+	enc.writeString(tx.Sender)
+	enc.writeUint(uint64(len(tx.Outputs)))
+	for _, output := range tx.Outputs {
+		enc.writeString(output.Receiver)
+		enc.writeUint(output.Value)
+	}
+	enc.writeUint(tx.ToStorage)
+	enc.writeUint(tx.Fee)
+	enc.writeUint(tx.LockTime)
+	enc.writeBytes(tx.Data)
+	if tx.Encrypted {
+		enc.writeUint(1)
+	} else {
+		enc.writeUint(0)
+	}
+	enc.writeString(tx.Asset)
+	if tx.Token == nil {
+		new(Token).encode(enc)
+	} else {
+		tx.Token.encode(enc)
+	}
+}
+
+func (tx *Transaction) encode(enc *encoder) {
+	tx.encodeSigned(enc)
+	enc.writeBytes(tx.CurrHash)
+	enc.writeBytes(tx.Signature)
+	if tx.Policy == nil {
+		new(Policy).encode(enc)
+	} else {
+		tx.Policy.encode(enc)
+	}
+	enc.writeUint(uint64(len(tx.Signatures)))
+	for _, signature := range tx.Signatures {
+		enc.writeBytes(signature)
+	}
+	if tx.Contract == nil {
+		new(Contract).encode(enc)
+	} else {
+		tx.Contract.encode(enc)
+	}
+	enc.writeBytes(tx.Preimage)
+	enc.writeBytes(tx.Script)
+	enc.writeUint(uint64(len(tx.Witness)))
+	for _, item := range tx.Witness {
+		enc.writeBytes(item)
+	}
+}
+
+func decodeTX(dec *decoder) *Transaction {
+	tx := &Transaction{
+		ChainID:   dec.readBytes(),
+		Nonce:     dec.readUint(),
+		PrevBlock: dec.readBytes(),
+		Sender:    dec.readString(),
+	}
+	count := dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		tx.Outputs = append(tx.Outputs, Output{
+			Receiver: dec.readString(),
+			Value:    dec.readUint(),
+		})
+	}
+	tx.ToStorage = dec.readUint()
+	tx.Fee = dec.readUint()
+	tx.LockTime = dec.readUint()
+	tx.Data = dec.readBytes()
+	tx.Encrypted = dec.readUint() != 0
+	tx.Asset = dec.readString()
+	if token := decodeToken(dec); token.Issuer != "" || token.Name != "" {
+		tx.Token = token
+	}
+	tx.CurrHash = dec.readBytes()
+	tx.Signature = dec.readBytes()
+	if policy := decodePolicy(dec); policy.Threshold != 0 || len(policy.Keys) != 0 {
+		tx.Policy = policy
+	}
+	count = dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		tx.Signatures = append(tx.Signatures, dec.readBytes())
+	}
+	if contract := decodeContract(dec); contract.Sender != "" || contract.Receiver != "" {
+		tx.Contract = contract
+	}
+	tx.Preimage = dec.readBytes()
+	tx.Script = dec.readBytes()
+	count = dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		tx.Witness = append(tx.Witness, dec.readBytes())
+	}
+	return tx
+}
+
+func (policy *Policy) encode(enc *encoder) {
+	enc.writeUint(policy.Threshold)
+	enc.writeUint(uint64(len(policy.Keys)))
+	for _, key := range policy.Keys {
+		enc.writeString(key)
+	}
+}
+
+func decodePolicy(dec *decoder) *Policy {
+	policy := &Policy{
+		Threshold: dec.readUint(),
+	}
+	count := dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		policy.Keys = append(policy.Keys, dec.readString())
+	}
+	return policy
+}
+
+func (contract *Contract) encode(enc *encoder) {
+	enc.writeString(contract.Sender)
+	enc.writeString(contract.Receiver)
+	enc.writeBytes(contract.HashLock)
+	enc.writeUint(contract.Timeout)
+}
+
+func decodeContract(dec *decoder) *Contract {
+	return &Contract{
+		Sender:   dec.readString(),
+		Receiver: dec.readString(),
+		HashLock: dec.readBytes(),
+		Timeout:  dec.readUint(),
+	}
+}
+
+func (token *Token) encode(enc *encoder) {
+	enc.writeString(token.Issuer)
+	enc.writeString(token.Name)
+	if token.Mintable {
+		enc.writeUint(1)
+	} else {
+		enc.writeUint(0)
+	}
+	enc.writeUint(token.Nonce)
+}
+
+func decodeToken(dec *decoder) *Token {
+	return &Token{
+		Issuer:   dec.readString(),
+		Name:     dec.readString(),
+		Mintable: dec.readUint() != 0,
+		Nonce:    dec.readUint(),
+	}
+}
+
+// Поля заголовка, которые покрываются его хешем.
+func (header *BlockHeader) encodeSigned(enc *encoder) {
+	enc.writeBytes(header.ChainID)
+	enc.writeBytes(header.TxRoot)
+	enc.writeBytes(header.MapRoot)
+	enc.writeUint(uint64(header.Bits))
+	enc.writeBytes(header.PrevHash)
+	enc.writeString(header.Miner)
+	enc.writeString(header.TimeStamp)
+}
+
+func (header *BlockHeader) encode(enc *encoder) {
+	header.encodeSigned(enc)
+	enc.writeBytes(header.CurrHash)
+	enc.writeUint(header.Nonce)
+	enc.writeBytes(header.Signature)
+}
+
+func decodeHeader(dec *decoder) *BlockHeader {
+	header := &BlockHeader{
+		ChainID: dec.readBytes(),
+		TxRoot:  dec.readBytes(),
+		MapRoot: dec.readBytes(),
+	}
+	bits := dec.readUint()
+	if bits > 0xFFFFFFFF && dec.err == nil {
+		dec.err = errors.New("bits is not valid")
+	}
+	header.Bits = uint32(bits)
+	header.PrevHash = dec.readBytes()
+	header.Miner = dec.readString()
+	header.TimeStamp = dec.readString()
+	header.CurrHash = dec.readBytes()
+	header.Nonce = dec.readUint()
+	header.Signature = dec.readBytes()
+	return header
+}
+
+func (body *BlockBody) encode(enc *encoder) {
+	enc.writeUint(uint64(len(body.Transactions)))
+	for i := range body.Transactions {
+		body.Transactions[i].encode(enc)
+	}
+	list := make([]string, 0, len(body.Mapping))
+	for addr := range body.Mapping {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+	enc.writeUint(uint64(len(list)))
+	for _, addr := range list {
+		enc.writeString(addr)
+		enc.writeUint(body.Mapping[addr])
+	}
+}
+
+func decodeBody(dec *decoder) *BlockBody {
+	body := &BlockBody{
+		Mapping: make(map[string]uint64),
+	}
+	count := dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		body.Transactions = append(body.Transactions, *decodeTX(dec))
+	}
+	count = dec.readCount()
+	prev := ""
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		addr := dec.readString()
+		if i > 0 && addr <= prev && dec.err == nil {
+			dec.err = errors.New("mapping is not sorted")
+		}
+		body.Mapping[addr] = dec.readUint()
+		prev = addr
+	}
+	return body
+}
+
+func (proof *MerkleProof) encode(enc *encoder) {
+	enc.writeBytes(proof.Hash)
+	enc.writeUint(proof.Index)
+	enc.writeUint(proof.Count)
+	enc.writeUint(uint64(len(proof.Branch)))
+	for _, hash := range proof.Branch {
+		enc.writeBytes(hash)
+	}
+}
+
+func decodeProof(dec *decoder) *MerkleProof {
+	proof := &MerkleProof{
+		Hash:  dec.readBytes(),
+		Index: dec.readUint(),
+		Count: dec.readUint(),
+	}
+	count := dec.readCount()
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		proof.Branch = append(proof.Branch, dec.readBytes())
+	}
+	return proof
+}
@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func testTransaction() *Transaction {
+	return &Transaction{
+		ChainID:   []byte{1, 2, 3},
+		Nonce:     7,
+		PrevBlock: []byte{4, 5},
+		Sender:    "sender",
+		Outputs:   []Output{{Receiver: "a", Value: 1}, {Receiver: "b", Value: 2}},
+		ToStorage: 1,
+		Fee:       3,
+		LockTime:  LOCKTIME_THRESHOLD + 1,
+		Data:      []byte("memo"),
+		Encrypted: true,
+		Asset:     "asset",
+		Token:     &Token{Issuer: "sender", Name: "coin", Mintable: true, Nonce: 7},
+		CurrHash:  []byte{6},
+		Signature: []byte{7},
+		Policy:    &Policy{Threshold: 1, Keys: []string{"k1", "k2"}},
+		Signatures: [][]byte{
+			{8},
+			{9},
+		},
+		Contract: &Contract{Sender: "s", Receiver: "r", HashLock: []byte{10}, Timeout: 5},
+		Preimage: []byte{11},
+		Script:   []byte{OP_TRUE},
+		Witness:  [][]byte{{12}},
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	header := &BlockHeader{
+		ChainID:   []byte{1},
+		CurrHash:  []byte{2},
+		PrevHash:  []byte{3},
+		Nonce:     4,
+		Bits:      TargetBits(8),
+		Miner:     "miner",
+		Signature: []byte{5},
+		TimeStamp: "2006-01-02T15:04:05Z",
+		TxRoot:    []byte{6},
+		MapRoot:   []byte{7},
+	}
+	body := &BlockBody{
+		Transactions: []Transaction{*testTransaction(), {Sender: STORAGE_CHAIN}},
+		Mapping:      map[string]uint64{"b": 2, "a": 1, "c": 0},
+	}
+	proof := &MerkleProof{
+		Hash:   []byte{1},
+		Index:  2,
+		Count:  3,
+		Branch: [][]byte{{4}, {5}},
+	}
+	spec := DefaultSpec("receiver")
+	tests := []struct {
+		name     string
+		data     []byte
+		reencode func(data []byte) []byte
+	}{
+		{"tx", EncodeTX(testTransaction()), func(data []byte) []byte {
+			if tx := DecodeTX(data); tx != nil {
+				return EncodeTX(tx)
+			}
+			return nil
+		}},
+		{"empty tx", EncodeTX(&Transaction{}), func(data []byte) []byte {
+			if tx := DecodeTX(data); tx != nil {
+				return EncodeTX(tx)
+			}
+			return nil
+		}},
+		{"header", EncodeHeader(header), func(data []byte) []byte {
+			if header := DecodeHeader(data); header != nil {
+				return EncodeHeader(header)
+			}
+			return nil
+		}},
+		{"body", EncodeBody(body), func(data []byte) []byte {
+			if body := DecodeBody(data); body != nil {
+				return EncodeBody(body)
+			}
+			return nil
+		}},
+		{"block", EncodeBlock(&Block{*header, *body}), func(data []byte) []byte {
+			if block := DecodeBlock(data); block != nil {
+				return EncodeBlock(block)
+			}
+			return nil
+		}},
+		{"records", EncodeRecords([]TxRecord{{Height: 3, Transaction: *testTransaction()}}), func(data []byte) []byte {
+			if records := DecodeRecords(data); records != nil {
+				return EncodeRecords(records)
+			}
+			return nil
+		}},
+		{"proof", EncodeProof(proof), func(data []byte) []byte {
+			if proof := DecodeProof(data); proof != nil {
+				return EncodeProof(proof)
+			}
+			return nil
+		}},
+		{"balance proof", EncodeBalanceProof(&BalanceProof{Height: 1, BlockHash: []byte{2}, Address: "a", Balance: 3, Proof: proof}), func(data []byte) []byte {
+			if proof := DecodeBalanceProof(data); proof != nil {
+				return EncodeBalanceProof(proof)
+			}
+			return nil
+		}},
+		{"spec", EncodeSpec(spec), func(data []byte) []byte {
+			if spec := DecodeSpec(data); spec != nil {
+				return EncodeSpec(spec)
+			}
+			return nil
+		}},
+	}
+	for _, test := range tests {
+		if data := test.reencode(test.data); !bytes.Equal(data, test.data) {
+			t.Errorf("%s: encoding is not canonical", test.name)
+		}
+		truncated := test.data[:len(test.data)-1]
+		if test.reencode(truncated) != nil {
+			t.Errorf("%s: truncated data is decoded", test.name)
+		}
+		trailing := append(append([]byte{}, test.data...), 0)
+		if test.reencode(trailing) != nil {
+			t.Errorf("%s: trailing data is decoded", test.name)
+		}
+		version := append([]byte{ENCODING_VERSION + 1}, test.data[1:]...)
+		if test.reencode(version) != nil {
+			t.Errorf("%s: unknown version is decoded", test.name)
+		}
+	}
+}
+
+func TestDecodeTX(t *testing.T) {
+	tx := testTransaction()
+	if decoded := DecodeTX(EncodeTX(tx)); !reflect.DeepEqual(decoded, tx) {
+		t.Errorf("DecodeTX() = %+v, want %+v", decoded, tx)
+	}
+}
+
+func TestMappingOrder(t *testing.T) {
+	first := &BlockBody{Mapping: make(map[string]uint64)}
+	second := &BlockBody{Mapping: make(map[string]uint64)}
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		first.Mapping[key] = uint64(i)
+	}
+	for i, key := range []string{"e", "d", "c", "b", "a"} {
+		second.Mapping[key] = uint64(4 - i)
+	}
+	if !bytes.Equal(EncodeBody(first), EncodeBody(second)) {
+		t.Error("mapping encoding depends on insertion order")
+	}
+}
+
+func TestReadCount(t *testing.T) {
+	tests := []struct {
+		count uint64
+		valid bool
+	}{
+		{0, true},
+		{8, true},
+		{9, false},
+		{math.MaxUint64, false},
+	}
+	for _, test := range tests {
+		enc := newEncoder()
+		enc.writeUint(test.count)
+		enc.writeUint(0)
+		dec := newDecoder(enc.bytes())
+		if count := dec.readCount(); (dec.err == nil) != test.valid || test.valid && count != test.count {
+			t.Errorf("readCount(%d) = %d, %v", test.count, count, dec.err)
+		}
+	}
+}
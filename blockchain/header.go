@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"math/big"
+	"time"
+)
+
+// bits - цель, рассчитанная для этой высоты по предкам заголовка.
+func (header *BlockHeader) IsValid(parent *BlockHeader, bits uint32) bool {
+	switch {
+	case header == nil || parent == nil:
+		return false
+	case header.Bits != bits || header.target().Sign() <= 0:
+		return false
+	case !bytes.Equal(header.hash(), header.CurrHash):
+		return false
+	case !bytes.Equal(header.PrevHash, parent.CurrHash):
+		return false
+	case !bytes.Equal(header.ChainID, parent.chainID()):
+		return false
+	case !header.signIsValid():
+		return false
+	case !header.proofIsValid():
+		return false
+	case !header.timeIsAfter(parent):
+		return false
+	}
+	return true
+}
+
+// Проверяет, что транзакция вместе с подписями входит в блок заголовка.
+func (header *BlockHeader) VerifyTransaction(tx *Transaction, proof *MerkleProof) bool {
+	switch {
+	case tx == nil || proof == nil:
+		return false
+	case !tx.hashIsValid():
+		return false
+	case !bytes.Equal(proof.Hash, txLeaf(tx)):
+		return false
+	}
+	return VerifyMerkleProof(header.TxRoot, proof)
+}
+
+func (header *BlockHeader) hash() []byte {
+	enc := newEncoder()
+	header.encodeSigned(enc)
+	return HashSum(enc.bytes())
+}
+
+// Ожидаемое число хешей для нахождения блока: 2^256 / (target + 1).
+func (header *BlockHeader) work() *big.Int {
+	target := header.target()
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// Идентификатор сети - хеш генезиса. У генезиса поле ChainID пустое,
+// остальные блоки наследуют идентификатор от родителя.
+func (header *BlockHeader) chainID() []byte {
+	if len(header.ChainID) == 0 {
+		return header.CurrHash
+	}
+	return header.ChainID
+}
+
+func (header *BlockHeader) target() *big.Int {
+	return CompactToBig(header.Bits)
+}
+
+func (header *BlockHeader) time() time.Time {
+	btime, err := time.Parse(time.RFC3339, header.TimeStamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return btime
+}
+
+func (header *BlockHeader) sign(priv *rsa.PrivateKey) []byte {
+	return Sign(priv, header.CurrHash)
+}
+
+func (header *BlockHeader) proof(ch chan bool) uint64 {
+	return ProofOfWork(header.CurrHash, header.target(), ch)
+}
+
+func (header *BlockHeader) signIsValid() bool {
+	return Verify(ParsePublic(header.Miner), header.CurrHash, header.Signature) == nil
+}
+
+func (header *BlockHeader) proofIsValid() bool {
+	intHash := big.NewInt(1)
+	hash := HashSum(bytes.Join(
+		[][]byte{
+			header.CurrHash,
+			ToBytes(header.Nonce),
+		},
+		[]byte{},
+	))
+	intHash.SetBytes(hash)
+	if intHash.Cmp(header.target()) == -1 {
+		return true
+	}
+	return false
+}
+
+func (header *BlockHeader) timeIsAfter(lheader *BlockHeader) bool {
+	btime, err := time.Parse(time.RFC3339, header.TimeStamp)
+	if err != nil {
+		return false
+	}
+	diff := time.Now().Sub(btime)
+	if diff < 0 {
+		return false
+	}
+	if lheader == nil {
+		return false
+	}
+	ltime, err := time.Parse(time.RFC3339, lheader.TimeStamp)
+	if err != nil {
+		return false
+	}
+	diff = btime.Sub(ltime)
+	return diff > 0
+}
@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Условный перевод с хеш-блокировкой. Средства, отправленные на адрес
+// контракта, получатель забирает, раскрыв прообраз HashLock, а
+// отправитель возвращает себе транзакцией с LockTime не меньше Timeout.
+type Contract struct {
+	Sender   string
+	Receiver string
+	HashLock []byte
+	Timeout  uint64
+}
+
+func NewContract(sender, receiver string, hashLock []byte, timeout uint64) *Contract {
+	contract := &Contract{
+		Sender:   sender,
+		Receiver: receiver,
+		HashLock: hashLock,
+		Timeout:  timeout,
+	}
+	if !contract.IsValid() {
+		return nil
+	}
+	return contract
+}
+
+func (contract *Contract) IsValid() bool {
+	switch {
+	case contract == nil:
+		return false
+	case contract.Sender == contract.Receiver:
+		return false
+	case ParsePublic(contract.Sender) == nil || ParsePublic(contract.Receiver) == nil:
+		return false
+	case len(contract.HashLock) != len(HashSum(nil)):
+		return false
+	case contract.Timeout == 0 || contract.Timeout >= LOCKTIME_THRESHOLD:
+		return false
+	}
+	return true
+}
+
+func (contract *Contract) Address() string {
+	return HTLC_PREFIX + Base64Encode(HashSum(EncodeContract(contract)))
+}
+
+func IsHTLC(address string) bool {
+	return strings.HasPrefix(address, HTLC_PREFIX)
+}
+
+// Получатель забирает средства контракта, раскрывая preimage.
+func NewClaimTransaction(user *User, contract *Contract, preimage []byte, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	tx := newContractTransaction(contract, params, outputs, fee)
+	tx.Preimage = preimage
+	tx.Signature = tx.sign(user.Private())
+	return tx
+}
+
+// Отправитель возвращает средства контракта. Транзакция заблокирована
+// до высоты Timeout и до того ждет в пуле.
+func NewRefundTransaction(user *User, contract *Contract, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	lockTime := params.LockTime
+	if lockTime < contract.Timeout || lockTime >= LOCKTIME_THRESHOLD {
+		lockTime = contract.Timeout
+	}
+	refund := *params
+	refund.LockTime = lockTime
+	tx := newContractTransaction(contract, &refund, outputs, fee)
+	tx.Signature = tx.sign(user.Private())
+	return tx
+}
+
+func newContractTransaction(contract *Contract, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	tx := newTx(contract.Address(), params, outputs, fee)
+	tx.Contract = contract
+	return tx
+}
+
+// Подпись получателя действительна с верным прообразом, подпись
+// отправителя - с блокировкой не раньше Timeout.
+func (tx *Transaction) htlcIsValid() bool {
+	contract := tx.Contract
+	switch {
+	case !contract.IsValid():
+		return false
+	case contract.Address() != tx.Sender:
+		return false
+	case Verify(ParsePublic(contract.Receiver), tx.CurrHash, tx.Signature) == nil:
+		return len(tx.Preimage) <= HTLC_PREIMAGE && bytes.Equal(HashSum(tx.Preimage), contract.HashLock)
+	case Verify(ParsePublic(contract.Sender), tx.CurrHash, tx.Signature) == nil:
+		return tx.LockTime >= contract.Timeout && tx.LockTime < LOCKTIME_THRESHOLD
+	}
+	return false
+}
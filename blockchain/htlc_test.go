@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestHTLC(t *testing.T) {
+	var (
+		miner    = NewUser(512)
+		alice    = NewUser(512)
+		bob      = NewUser(512)
+		secret   = []byte("secret")
+		contract = NewContract(alice.Address(), bob.Address(), HashSum(secret), 4)
+		chain    = testChain(t, NewMemoryStore(), testSpec(alice))
+	)
+	if contract == nil {
+		t.Fatal("contract is null")
+	}
+	fund := NewTransaction(alice, chain.TxParams(alice.Address()), []Output{{Receiver: contract.Address(), Value: 20}}, 0)
+	testMine(t, chain, miner, fund)
+	var (
+		claim = func(user *User, preimage []byte) *Transaction {
+			return NewClaimTransaction(user, contract, preimage, chain.TxParams(contract.Address()), []Output{{Receiver: user.Address(), Value: 10}}, 0)
+		}
+		refund = func(user *User) *Transaction {
+			return NewRefundTransaction(user, contract, chain.TxParams(contract.Address()), []Output{{Receiver: user.Address(), Value: 10}}, 0)
+		}
+		check = func(name string, tx *Transaction, valid bool) {
+			block := testBlock(t, chain, miner, NewTransaction(alice, chain.TxParams(alice.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0))
+			block.Transactions[0] = *tx
+			testReseal(chain, miner, block)
+			if err := testAccepts(chain, block); (err == nil) != valid {
+				t.Errorf("%s: AcceptBlock() = %v, valid %v", name, err, valid)
+			}
+		}
+	)
+	if tx := refund(alice); tx.LockTime != contract.Timeout {
+		t.Errorf("refund LockTime = %d, want %d", tx.LockTime, contract.Timeout)
+	}
+	check("claim", claim(bob, secret), true)
+	check("claim with wrong preimage", claim(bob, []byte("wrong")), false)
+	check("claim by sender", claim(alice, secret), false)
+	check("claim by outsider", claim(miner, secret), false)
+	check("refund before timeout", refund(alice), false)
+	check("refund by receiver", refund(bob), false)
+	// Следующий блок получит высоту Timeout.
+	testMine(t, chain, miner, NewTransaction(alice, chain.TxParams(alice.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0))
+	check("refund at timeout", refund(alice), true)
+	check("refund by receiver at timeout", refund(bob), false)
+	check("claim at timeout", claim(bob, secret), true)
+	testMine(t, chain, miner, claim(bob, secret))
+	if balance := chain.Balance(bob.Address(), chain.Size()); balance != 10 {
+		t.Errorf("receiver balance = %d, want 10", balance)
+	}
+	if balance := chain.Balance(contract.Address(), chain.Size()); balance != 10 {
+		t.Errorf("contract balance = %d, want 10", balance)
+	}
+	testMine(t, chain, miner, refund(alice))
+	if balance := chain.Balance(contract.Address(), chain.Size()); balance != 0 {
+		t.Errorf("contract balance after refund = %d, want 0", balance)
+	}
+}
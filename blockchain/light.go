@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// Цепочка заголовков легкого клиента. Тела блоков не хранятся:
+// транзакции и балансы проверяются доказательствами Меркла от узлов.
+type HeaderChain struct {
+	Spec    *ChainSpec
+	Headers []*BlockHeader
+	works   []*big.Int
+	heights map[string]uint64
+}
+
+// Генезис должен ссылаться на хеш спецификации spec.
+func NewHeaderChain(genesis *BlockHeader, spec *ChainSpec) *HeaderChain {
+	switch {
+	case genesis == nil || !spec.IsValid():
+		return nil
+	case !bytes.Equal(genesis.PrevHash, spec.Hash()):
+		return nil
+	case !bytes.Equal(genesis.hash(), genesis.CurrHash):
+		return nil
+	}
+	chain := &HeaderChain{
+		Spec:    spec,
+		heights: make(map[string]uint64),
+	}
+	chain.push(genesis)
+	return chain
+}
+
+func (chain *HeaderChain) ID() []byte {
+	return chain.Headers[0].CurrHash
+}
+
+func (chain *HeaderChain) Size() uint64 {
+	return uint64(len(chain.Headers))
+}
+
+func (chain *HeaderChain) Header(height uint64) *BlockHeader {
+	if height == 0 || height > chain.Size() {
+		return nil
+	}
+	return chain.Headers[height-1]
+}
+
+func (chain *HeaderChain) Work() *big.Int {
+	return new(big.Int).Set(chain.works[len(chain.works)-1])
+}
+
+func (chain *HeaderChain) Locator() [][]byte {
+	return locator(chain.Size(), func(height uint64) []byte {
+		return chain.Headers[height-1].CurrHash
+	})
+}
+
+// Принимает заголовки, продолжающие известный заголовок. Если их ветка
+// тяжелее текущей, она заменяет цепочку после общего предка.
+func (chain *HeaderChain) Append(headers []*BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	for _, header := range headers {
+		if header == nil {
+			return errors.New("header is null")
+		}
+	}
+	parent, ok := chain.heights[Base64Encode(headers[0].PrevHash)]
+	if !ok {
+		return ErrUnknownParent
+	}
+	var (
+		work    = new(big.Int).Set(chain.works[parent-1])
+		prev    = chain.Headers[parent-1]
+		checked = make(map[string]*BlockHeader)
+	)
+	ancestor := func(hash []byte) *BlockHeader {
+		if header, ok := checked[Base64Encode(hash)]; ok {
+			return header
+		}
+		if height, ok := chain.heights[Base64Encode(hash)]; ok {
+			return chain.Headers[height-1]
+		}
+		return nil
+	}
+	for _, header := range headers {
+		if !header.IsValid(prev, chain.Spec.nextBits(prev, ancestor)) {
+			return errors.New("header is not valid")
+		}
+		work.Add(work, header.work())
+		checked[Base64Encode(header.CurrHash)] = header
+		prev = header
+	}
+	if work.Cmp(chain.Work()) <= 0 {
+		return nil
+	}
+	for _, header := range chain.Headers[parent:] {
+		delete(chain.heights, Base64Encode(header.CurrHash))
+	}
+	chain.Headers = chain.Headers[:parent]
+	chain.works = chain.works[:parent]
+	for _, header := range headers {
+		chain.push(header)
+	}
+	return nil
+}
+
+func (chain *HeaderChain) VerifyTransaction(tx *Transaction, height uint64, proof *MerkleProof) bool {
+	header := chain.Header(height)
+	if header == nil {
+		return false
+	}
+	return header.VerifyTransaction(tx, proof)
+}
+
+// Подтверждает баланс на высоте доказательства. Что баланс не менялся
+// после этой высоты, доказательство не показывает.
+func (chain *HeaderChain) VerifyBalance(proof *BalanceProof) bool {
+	if proof == nil || proof.Proof == nil {
+		return false
+	}
+	header := chain.Header(proof.Height)
+	switch {
+	case header == nil:
+		return false
+	case !bytes.Equal(header.CurrHash, proof.BlockHash):
+		return false
+	case !bytes.Equal(proof.Proof.Hash, mapLeaf(proof.Address, proof.Balance)):
+		return false
+	}
+	return VerifyMerkleProof(header.MapRoot, proof.Proof)
+}
+
+func (chain *HeaderChain) push(header *BlockHeader) {
+	work := header.work()
+	if len(chain.works) != 0 {
+		work.Add(work, chain.works[len(chain.works)-1])
+	}
+	chain.Headers = append(chain.Headers, header)
+	chain.works = append(chain.works, work)
+	chain.heights[Base64Encode(header.CurrHash)] = chain.Size()
+}
@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+type MemoryStore struct {
+	update   sync.Mutex
+	mutex    sync.RWMutex
+	spec     []byte
+	blocks   [][]byte
+	hashes   [][]byte
+	heights  map[string]uint64
+	accounts map[string][]accountState
+	txs      map[string]txLocation
+	history  map[string][]txLocation
+	known    map[string]knownBlock
+}
+
+type knownBlock struct {
+	block []byte
+	work  *big.Int
+}
+
+type txLocation struct {
+	height   uint64
+	position uint64
+}
+
+type accountState struct {
+	height  uint64
+	balance uint64
+	nonce   uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		heights:  make(map[string]uint64),
+		accounts: make(map[string][]accountState),
+		txs:      make(map[string]txLocation),
+		history:  make(map[string][]txLocation),
+		known:    make(map[string]knownBlock),
+	}
+}
+
+func (store *MemoryStore) Spec() *ChainSpec {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if store.spec == nil {
+		return nil
+	}
+	return DecodeSpec(store.spec)
+}
+
+func (store *MemoryStore) PutSpec(spec *ChainSpec) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.spec = EncodeSpec(spec)
+	return nil
+}
+
+func (store *MemoryStore) Size() uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return uint64(len(store.blocks))
+}
+
+func (store *MemoryStore) LastHash() []byte {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if len(store.blocks) == 0 {
+		return nil
+	}
+	return store.hashes[len(store.hashes)-1]
+}
+
+func (store *MemoryStore) Block(height uint64) *Block {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if height == 0 || height > uint64(len(store.blocks)) {
+		return nil
+	}
+	return DecodeBlock(store.blocks[height-1])
+}
+
+func (store *MemoryStore) BlockByHash(hash []byte) (*Block, uint64) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	height, ok := store.heights[Base64Encode(hash)]
+	if !ok {
+		return nil, 0
+	}
+	return DecodeBlock(store.blocks[height-1]), height
+}
+
+func (store *MemoryStore) PutBlock(block *Block) error {
+	store.update.Lock()
+	defer store.update.Unlock()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	hash := Base64Encode(block.CurrHash)
+	if _, ok := store.heights[hash]; ok {
+		return errors.New("block already exists")
+	}
+	store.blocks = append(store.blocks, EncodeBlock(block))
+	store.hashes = append(store.hashes, block.CurrHash)
+	height := uint64(len(store.blocks))
+	store.heights[hash] = height
+	nonces := block.nonces()
+	for addr, value := range block.Mapping {
+		states := store.accounts[addr]
+		nonce, ok := nonces[addr]
+		if !ok && len(states) != 0 {
+			nonce = states[len(states)-1].nonce
+		}
+		store.accounts[addr] = append(states, accountState{
+			height:  height,
+			balance: value,
+			nonce:   nonce,
+		})
+	}
+	for i, tx := range block.Transactions {
+		location := txLocation{
+			height:   height,
+			position: uint64(i),
+		}
+		if _, ok := store.txs[Base64Encode(tx.CurrHash)]; !ok {
+			store.txs[Base64Encode(tx.CurrHash)] = location
+		}
+		for _, addr := range tx.addresses() {
+			store.history[addr] = append(store.history[addr], location)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) Truncate(height uint64) error {
+	store.update.Lock()
+	defer store.update.Unlock()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for uint64(len(store.blocks)) > height {
+		last := uint64(len(store.blocks))
+		block := DecodeBlock(store.blocks[last-1])
+		store.blocks = store.blocks[:last-1]
+		store.hashes = store.hashes[:last-1]
+		delete(store.heights, Base64Encode(block.CurrHash))
+		for addr := range block.Mapping {
+			states := store.accounts[addr]
+			if len(states) != 0 && states[len(states)-1].height == last {
+				store.accounts[addr] = states[:len(states)-1]
+			}
+		}
+		for _, tx := range block.Transactions {
+			if location, ok := store.txs[Base64Encode(tx.CurrHash)]; ok && location.height == last {
+				delete(store.txs, Base64Encode(tx.CurrHash))
+			}
+			for _, addr := range tx.addresses() {
+				locations := store.history[addr]
+				for len(locations) != 0 && locations[len(locations)-1].height == last {
+					locations = locations[:len(locations)-1]
+				}
+				store.history[addr] = locations
+			}
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) SaveBlock(block *Block, work *big.Int) error {
+	store.update.Lock()
+	defer store.update.Unlock()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.known[Base64Encode(block.CurrHash)] = knownBlock{
+		block: EncodeBlock(block),
+		work:  new(big.Int).Set(work),
+	}
+	return nil
+}
+
+func (store *MemoryStore) LoadBlock(hash []byte) (*Block, *big.Int) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	known, ok := store.known[Base64Encode(hash)]
+	if !ok {
+		return nil, nil
+	}
+	return DecodeBlock(known.block), new(big.Int).Set(known.work)
+}
+
+func (store *MemoryStore) RemoveBlock(hash []byte) error {
+	store.update.Lock()
+	defer store.update.Unlock()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.known, Base64Encode(hash))
+	return nil
+}
+
+func (store *MemoryStore) Balance(address string, height uint64) uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	states := store.accounts[address]
+	i := sort.Search(len(states), func(i int) bool {
+		return states[i].height > height
+	})
+	if i == 0 {
+		return 0
+	}
+	return states[i-1].balance
+}
+
+func (store *MemoryStore) BalanceHeight(address string, height uint64) uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	states := store.accounts[address]
+	i := sort.Search(len(states), func(i int) bool {
+		return states[i].height > height
+	})
+	if i == 0 {
+		return 0
+	}
+	return states[i-1].height
+}
+
+func (store *MemoryStore) Nonce(address string, height uint64) uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	states := store.accounts[address]
+	i := sort.Search(len(states), func(i int) bool {
+		return states[i].height > height
+	})
+	if i == 0 {
+		return 0
+	}
+	return states[i-1].nonce
+}
+
+func (store *MemoryStore) Transaction(hash []byte) (*Transaction, uint64) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	location, ok := store.txs[Base64Encode(hash)]
+	if !ok {
+		return nil, 0
+	}
+	block := DecodeBlock(store.blocks[location.height-1])
+	return &block.Transactions[location.position], location.height
+}
+
+func (store *MemoryStore) History(address string, offset, limit uint64) []TxRecord {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	var (
+		records   []TxRecord
+		locations = store.history[address]
+	)
+	for i := uint64(len(locations)); i > 0 && uint64(len(records)) < limit; i-- {
+		if offset != 0 {
+			offset--
+			continue
+		}
+		location := locations[i-1]
+		block := DecodeBlock(store.blocks[location.height-1])
+		records = append(records, TxRecord{
+			Height:      location.height,
+			Transaction: block.Transactions[location.position],
+		})
+	}
+	return records
+}
+
+// Выполняет fn над копией хранилища и заменяет состояние
+// только при успешном завершении.
+func (store *MemoryStore) Update(fn func(Store) error) error {
+	store.update.Lock()
+	defer store.update.Unlock()
+	view := store.clone()
+	if err := fn(view); err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.spec = view.spec
+	store.blocks = view.blocks
+	store.hashes = view.hashes
+	store.heights = view.heights
+	store.accounts = view.accounts
+	store.txs = view.txs
+	store.history = view.history
+	store.known = view.known
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+func (store *MemoryStore) clone() *MemoryStore {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	view := NewMemoryStore()
+	view.spec = store.spec
+	view.blocks = append([][]byte(nil), store.blocks...)
+	view.hashes = append([][]byte(nil), store.hashes...)
+	for hash, height := range store.heights {
+		view.heights[hash] = height
+	}
+	for addr, states := range store.accounts {
+		view.accounts[addr] = append([]accountState(nil), states...)
+	}
+	for hash, location := range store.txs {
+		view.txs[hash] = location
+	}
+	for addr, locations := range store.history {
+		view.history[addr] = append([]txLocation(nil), locations...)
+	}
+	for hash, known := range store.known {
+		view.known[hash] = known
+	}
+	return view
+}
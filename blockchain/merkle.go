@@ -0,0 +1,101 @@
+package blockchain
+
+import "bytes"
+
+// Корень дерева Меркла. Листья и узлы хешируются с разными префиксами,
+// а непарный узел уровня поднимается выше без изменений.
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return nil
+	}
+	level := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		level[i] = merkleLeaf(hash)
+	}
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+func NewMerkleProof(hashes [][]byte, index uint64) *MerkleProof {
+	if index >= uint64(len(hashes)) {
+		return nil
+	}
+	proof := &MerkleProof{
+		Hash:  hashes[index],
+		Index: index,
+		Count: uint64(len(hashes)),
+	}
+	level := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		level[i] = merkleLeaf(hash)
+	}
+	for i := index; len(level) > 1; i /= 2 {
+		if i%2 == 1 {
+			proof.Branch = append(proof.Branch, level[i-1])
+		} else if i+1 < uint64(len(level)) {
+			proof.Branch = append(proof.Branch, level[i+1])
+		}
+		level = merkleLevel(level)
+	}
+	return proof
+}
+
+func VerifyMerkleProof(root []byte, proof *MerkleProof) bool {
+	if proof == nil || proof.Index >= proof.Count {
+		return false
+	}
+	var (
+		hash   = merkleLeaf(proof.Hash)
+		branch = proof.Branch
+	)
+	for i, count := proof.Index, proof.Count; count > 1; i, count = i/2, (count+1)/2 {
+		if i%2 == 0 && i+1 == count {
+			continue
+		}
+		if len(branch) == 0 {
+			return false
+		}
+		if i%2 == 1 {
+			hash = merkleNode(branch[0], hash)
+		} else {
+			hash = merkleNode(hash, branch[0])
+		}
+		branch = branch[1:]
+	}
+	return len(branch) == 0 && bytes.Equal(hash, root)
+}
+
+func merkleLevel(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleLeaf(hash []byte) []byte {
+	return HashSum(bytes.Join(
+		[][]byte{
+			{MERKLE_LEAF},
+			hash,
+		},
+		[]byte{},
+	))
+}
+
+func merkleNode(left, right []byte) []byte {
+	return HashSum(bytes.Join(
+		[][]byte{
+			{MERKLE_NODE},
+			left,
+			right,
+		},
+		[]byte{},
+	))
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testHashes(n int) [][]byte {
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		hashes[i] = HashSum([]byte{byte(i)})
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	var (
+		h    = testHashes(5)
+		leaf = func(i int) []byte { return merkleLeaf(h[i]) }
+	)
+	tests := []struct {
+		count int
+		root  []byte
+	}{
+		{0, nil},
+		{1, leaf(0)},
+		{2, merkleNode(leaf(0), leaf(1))},
+		{3, merkleNode(merkleNode(leaf(0), leaf(1)), leaf(2))},
+		{4, merkleNode(merkleNode(leaf(0), leaf(1)), merkleNode(leaf(2), leaf(3)))},
+		{5, merkleNode(merkleNode(merkleNode(leaf(0), leaf(1)), merkleNode(leaf(2), leaf(3))), leaf(4))},
+	}
+	for _, test := range tests {
+		if root := MerkleRoot(h[:test.count]); !bytes.Equal(root, test.root) {
+			t.Errorf("MerkleRoot(%d hashes) = %x, want %x", test.count, root, test.root)
+		}
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		var (
+			hashes = testHashes(n)
+			root   = MerkleRoot(hashes)
+		)
+		if NewMerkleProof(hashes, uint64(n)) != nil {
+			t.Errorf("n=%d: proof for index out of range", n)
+		}
+		for i := 0; i < n; i++ {
+			proof := NewMerkleProof(hashes, uint64(i))
+			if !VerifyMerkleProof(root, proof) {
+				t.Errorf("n=%d i=%d: valid proof is rejected", n, i)
+				continue
+			}
+			for name, bad := range badProofs(proof) {
+				if VerifyMerkleProof(root, bad) {
+					t.Errorf("n=%d i=%d: %s proof is accepted", n, i, name)
+				}
+			}
+		}
+	}
+}
+
+// Искаженные копии доказательства. Искажения ветви и соседнего индекса
+// имеют смысл только при непустой ветви.
+func badProofs(proof *MerkleProof) map[string]*MerkleProof {
+	bad := make(map[string]*MerkleProof)
+	edit := func(name string, fn func(p *MerkleProof)) {
+		p := *proof
+		p.Branch = append([][]byte{}, proof.Branch...)
+		fn(&p)
+		bad[name] = &p
+	}
+	edit("other hash", func(p *MerkleProof) { p.Hash = HashSum(p.Hash) })
+	edit("index out of count", func(p *MerkleProof) { p.Index = p.Count })
+	edit("extra branch", func(p *MerkleProof) { p.Branch = append(p.Branch, p.Hash) })
+	if len(proof.Branch) != 0 {
+		edit("short branch", func(p *MerkleProof) { p.Branch = p.Branch[1:] })
+		edit("other branch", func(p *MerkleProof) { p.Branch[0] = HashSum(p.Branch[0]) })
+		edit("other index", func(p *MerkleProof) { p.Index ^= 1 })
+	}
+	return bad
+}
+
+func TestProveTransaction(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		chain = testChain(t, NewMemoryStore(), testSpec(user))
+		tx    = NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+		block = testMine(t, chain, miner, tx)
+		proof = chain.ProveTransaction(block.CurrHash, tx.CurrHash)
+	)
+	if !block.VerifyTransaction(tx, proof) {
+		t.Fatal("valid proof is rejected")
+	}
+	edits := map[string]func(tx *Transaction){
+		"signature": func(tx *Transaction) { tx.Signature = HashSum(tx.Signature) },
+		"witness":   func(tx *Transaction) { tx.Witness = [][]byte{{1}} },
+		"script":    func(tx *Transaction) { tx.Script = []byte{OP_TRUE} },
+		"policy":    func(tx *Transaction) { tx.Policy = &Policy{Threshold: 1, Keys: []string{user.Address()}} },
+	}
+	for name, edit := range edits {
+		other := *tx
+		edit(&other)
+		if block.VerifyTransaction(&other, proof) {
+			t.Errorf("%s: changed transaction is proved", name)
+		}
+	}
+}
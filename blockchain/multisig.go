@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Политика счета с несколькими ключами: транзакция действительна, если
+// ее подписали не менее Threshold владельцев ключей из Keys. Адрес счета
+// выводится из хеша политики, поэтому одинаковые политики дают один адрес.
+type Policy struct {
+	Threshold uint64
+	Keys      []string
+}
+
+// Ключи сортируются и очищаются от повторов.
+func NewPolicy(threshold uint64, keys []string) *Policy {
+	seen := make(map[string]bool)
+	policy := &Policy{
+		Threshold: threshold,
+	}
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			policy.Keys = append(policy.Keys, key)
+		}
+	}
+	sort.Strings(policy.Keys)
+	if !policy.IsValid() {
+		return nil
+	}
+	return policy
+}
+
+func (policy *Policy) IsValid() bool {
+	switch {
+	case policy == nil:
+		return false
+	case policy.Threshold == 0 || policy.Threshold > uint64(len(policy.Keys)):
+		return false
+	case len(policy.Keys) > MULTISIG_KEYS:
+		return false
+	}
+	for i, key := range policy.Keys {
+		if i > 0 && key <= policy.Keys[i-1] {
+			return false
+		}
+		if ParsePublic(key) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (policy *Policy) Address() string {
+	return MULTISIG_PREFIX + Base64Encode(HashSum(EncodePolicy(policy)))
+}
+
+func IsMultisig(address string) bool {
+	return strings.HasPrefix(address, MULTISIG_PREFIX)
+}
+
+// Неподписанная транзакция со счета policy. Подписи добавляют
+// владельцы ключей через SignMultisig.
+func NewMultisigTransaction(policy *Policy, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	tx := newTx(policy.Address(), params, outputs, fee)
+	tx.Policy = policy
+	tx.Signatures = make([][]byte, len(policy.Keys))
+	return tx
+}
+
+func (tx *Transaction) SignMultisig(user *User) error {
+	if tx.Policy == nil || len(tx.Signatures) != len(tx.Policy.Keys) {
+		return errors.New("tx is not multisig")
+	}
+	if !tx.hashIsValid() {
+		return errors.New("tx hash is not valid")
+	}
+	for i, key := range tx.Policy.Keys {
+		if key == user.Address() {
+			tx.Signatures[i] = tx.sign(user.Private())
+			return nil
+		}
+	}
+	return errors.New("user is not in policy")
+}
+
+// Число действительных подписей под транзакцией со счета политики.
+func (tx *Transaction) Signed() uint64 {
+	if tx.Policy == nil || len(tx.Signatures) != len(tx.Policy.Keys) {
+		return 0
+	}
+	var count uint64
+	for i, key := range tx.Policy.Keys {
+		pub := ParsePublic(key)
+		if pub != nil && tx.Signatures[i] != nil && Verify(pub, tx.CurrHash, tx.Signatures[i]) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (tx *Transaction) multisigIsValid() bool {
+	switch {
+	case !tx.Policy.IsValid():
+		return false
+	case tx.Policy.Address() != tx.Sender:
+		return false
+	}
+	return tx.Signed() >= tx.Policy.Threshold
+}
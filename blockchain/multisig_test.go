@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestMultisig(t *testing.T) {
+	var (
+		miner      = NewUser(512)
+		user       = NewUser(512)
+		k1, k2, k3 = NewUser(512), NewUser(512), NewUser(512)
+		outsider   = NewUser(512)
+		policy     = NewPolicy(2, []string{k1.Address(), k2.Address(), k3.Address()})
+		chain      = testChain(t, NewMemoryStore(), testSpec(user))
+	)
+	if policy == nil {
+		t.Fatal("policy is null")
+	}
+	fund := NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: policy.Address(), Value: 50}}, 0)
+	testMine(t, chain, miner, fund)
+	spend := func(signers ...*User) *Transaction {
+		tx := NewMultisigTransaction(policy, chain.TxParams(policy.Address()), []Output{{Receiver: miner.Address(), Value: 5}}, 1)
+		for _, signer := range signers {
+			if err := tx.SignMultisig(signer); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tx
+	}
+	if err := spend().SignMultisig(outsider); err == nil {
+		t.Error("outsider signed the transaction")
+	}
+	other := NewPolicy(2, []string{k1.Address(), k2.Address()})
+	tests := []struct {
+		name  string
+		tx    *Transaction
+		valid bool
+	}{
+		{"no signatures", spend(), false},
+		{"below threshold", spend(k2), false},
+		{"same key twice", spend(k1, k1), false},
+		{"threshold", spend(k1, k3), true},
+		{"all keys", spend(k1, k2, k3), true},
+		{"signature in other slot", func() *Transaction {
+			tx := spend(k1, k2)
+			tx.Signatures[0], tx.Signatures[2] = tx.Signatures[2], tx.Signatures[0]
+			return tx
+		}(), false},
+		{"other policy", func() *Transaction {
+			tx := spend(k1, k2)
+			tx.Policy = other
+			return tx
+		}(), false},
+	}
+	for _, test := range tests {
+		if valid := test.tx.IsValid(chain); valid != test.valid {
+			t.Errorf("%s: IsValid() = %v, want %v", test.name, valid, test.valid)
+		}
+		block := testBlock(t, chain, miner, NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0))
+		block.Transactions[0] = *test.tx
+		testReseal(chain, miner, block)
+		if err := testAccepts(chain, block); (err == nil) != test.valid {
+			t.Errorf("%s: AcceptBlock() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+	testMine(t, chain, miner, spend(k2, k3))
+	if balance := chain.Balance(policy.Address(), chain.Size()); balance != 44 {
+		t.Errorf("policy balance = %d, want 44", balance)
+	}
+}
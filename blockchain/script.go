@@ -0,0 +1,406 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Стековый язык условий расходования. Адрес счета выводится из хеша
+// скрипта; транзакция с такого счета несет сам скрипт и данные Witness,
+// которые кладутся на стек перед выполнением. Циклов нет, каждая
+// операция расходует газ, поэтому выполнение ограничено и одинаково
+// на всех узлах. Примеры:
+//
+//	2 <key1> <key2> <key3> 3 CHECKMULTISIG
+//	<height> CHECKLOCKTIME <key> CHECKSIG
+//	IF HASH 0x<hash> EQUALVERIFY <receiver> CHECKSIG
+//	ELSE <height> CHECKLOCKTIME <sender> CHECKSIG ENDIF
+const (
+	OP_FALSE         = 0x00
+	OP_PUSH          = 0x01
+	OP_TRUE          = 0x02
+	OP_DUP           = 0x10
+	OP_DROP          = 0x11
+	OP_SWAP          = 0x12
+	OP_EQUAL         = 0x20
+	OP_VERIFY        = 0x21
+	OP_EQUALVERIFY   = 0x22
+	OP_HASH          = 0x30
+	OP_CHECKSIG      = 0x40
+	OP_CHECKMULTISIG = 0x41
+	OP_CHECKLOCKTIME = 0x50
+	OP_IF            = 0x60
+	OP_ELSE          = 0x61
+	OP_ENDIF         = 0x62
+)
+
+var opcodes = map[string]byte{
+	"FALSE":         OP_FALSE,
+	"TRUE":          OP_TRUE,
+	"DUP":           OP_DUP,
+	"DROP":          OP_DROP,
+	"SWAP":          OP_SWAP,
+	"EQUAL":         OP_EQUAL,
+	"VERIFY":        OP_VERIFY,
+	"EQUALVERIFY":   OP_EQUALVERIFY,
+	"HASH":          OP_HASH,
+	"CHECKSIG":      OP_CHECKSIG,
+	"CHECKMULTISIG": OP_CHECKMULTISIG,
+	"CHECKLOCKTIME": OP_CHECKLOCKTIME,
+	"IF":            OP_IF,
+	"ELSE":          OP_ELSE,
+	"ENDIF":         OP_ENDIF,
+}
+
+// Газ операций, не указанных здесь, - 1.
+var gasCosts = map[byte]uint64{
+	OP_HASH:     10,
+	OP_CHECKSIG: 50,
+}
+
+var ErrScriptFailed = errors.New("script failed")
+
+// Переводит текст скрипта в байты. Слова - имена операций, числа,
+// данные в hex с префиксом 0x или в Base64, как адреса.
+func ParseScript(text string) ([]byte, error) {
+	var script []byte
+	for _, word := range strings.Fields(text) {
+		if op, ok := opcodes[strings.ToUpper(word)]; ok {
+			script = append(script, op)
+			continue
+		}
+		var data []byte
+		if strings.HasPrefix(word, "0x") {
+			var err error
+			if data, err = hex.DecodeString(word[2:]); err != nil {
+				return nil, err
+			}
+		} else if num, err := strconv.ParseUint(word, 10, 64); err == nil {
+			data = numBytes(num)
+		} else if data = Base64Decode(word); data == nil {
+			return nil, errors.New("unknown word: " + word)
+		}
+		if len(data) > SCRIPT_ITEM {
+			return nil, errors.New("data is too large")
+		}
+		script = append(script, OP_PUSH, byte(len(data)>>8), byte(len(data)))
+		script = append(script, data...)
+	}
+	if len(script) > SCRIPT_SIZE {
+		return nil, errors.New("script is too large")
+	}
+	return script, nil
+}
+
+func ScriptAddress(script []byte) string {
+	return SCRIPT_PREFIX + Base64Encode(HashSum(script))
+}
+
+func IsScript(address string) bool {
+	return strings.HasPrefix(address, SCRIPT_PREFIX)
+}
+
+// Неподписанная транзакция со счета скрипта. Подписи и другие данные
+// добавляются в Witness в порядке, которого ждет скрипт.
+func NewScriptTransaction(script []byte, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	tx := newTx(ScriptAddress(script), params, outputs, fee)
+	tx.Script = script
+	return tx
+}
+
+func (tx *Transaction) scriptIsValid() bool {
+	return ScriptAddress(tx.Script) == tx.Sender && tx.RunScript() == nil
+}
+
+// Выполняет Script над Witness. Скрипт выполнен, если он дошел до
+// конца без ошибок и на вершине стека истина.
+func (tx *Transaction) RunScript() error {
+	if len(tx.Script) > SCRIPT_SIZE || len(tx.Witness) > SCRIPT_STACK {
+		return errors.New("script is too large")
+	}
+	vm := &machine{
+		tx:  tx,
+		gas: SCRIPT_GAS,
+	}
+	for _, item := range tx.Witness {
+		if err := vm.push(item); err != nil {
+			return err
+		}
+	}
+	for pc := 0; pc < len(tx.Script); {
+		op := tx.Script[pc]
+		pc++
+		var data []byte
+		if op == OP_PUSH {
+			if pc+2 > len(tx.Script) {
+				return errors.New("push out of script")
+			}
+			size := int(tx.Script[pc])<<8 | int(tx.Script[pc+1])
+			pc += 2
+			if size > SCRIPT_ITEM || pc+size > len(tx.Script) {
+				return errors.New("push out of script")
+			}
+			data = tx.Script[pc : pc+size]
+			pc += size
+		}
+		if err := vm.step(op, data); err != nil {
+			return err
+		}
+	}
+	if len(vm.branches) != 0 {
+		return errors.New("IF without ENDIF")
+	}
+	if len(vm.stack) == 0 || !isTrue(vm.stack[len(vm.stack)-1]) {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+type machine struct {
+	tx       *Transaction
+	stack    [][]byte
+	branches []bool
+	gas      uint64
+}
+
+func (vm *machine) step(op byte, data []byte) error {
+	cost, ok := gasCosts[op]
+	if !ok {
+		cost = 1
+	}
+	if cost > vm.gas {
+		return errors.New("out of gas")
+	}
+	vm.gas -= cost
+	switch op {
+	case OP_IF:
+		cond := false
+		if vm.executing() {
+			item, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			cond = isTrue(item)
+		}
+		vm.branches = append(vm.branches, cond)
+		return nil
+	case OP_ELSE:
+		if len(vm.branches) == 0 {
+			return errors.New("ELSE without IF")
+		}
+		vm.branches[len(vm.branches)-1] = !vm.branches[len(vm.branches)-1]
+		return nil
+	case OP_ENDIF:
+		if len(vm.branches) == 0 {
+			return errors.New("ENDIF without IF")
+		}
+		vm.branches = vm.branches[:len(vm.branches)-1]
+		return nil
+	}
+	if !vm.executing() {
+		return nil
+	}
+	switch op {
+	case OP_FALSE:
+		return vm.push(nil)
+	case OP_TRUE:
+		return vm.push([]byte{1})
+	case OP_PUSH:
+		return vm.push(data)
+	case OP_DUP:
+		item, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(item)
+		return vm.push(item)
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+	case OP_SWAP:
+		items, err := vm.popN(2)
+		if err != nil {
+			return err
+		}
+		vm.push(items[1])
+		return vm.push(items[0])
+	case OP_EQUAL, OP_EQUALVERIFY:
+		items, err := vm.popN(2)
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(items[0], items[1])
+		if op == OP_EQUALVERIFY {
+			return verify(equal)
+		}
+		return vm.push(boolBytes(equal))
+	case OP_VERIFY:
+		item, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return verify(isTrue(item))
+	case OP_HASH:
+		item, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(HashSum(item))
+	case OP_CHECKSIG:
+		items, err := vm.popN(2)
+		if err != nil {
+			return err
+		}
+		return vm.push(boolBytes(vm.checkSig(items[1], items[0])))
+	case OP_CHECKMULTISIG:
+		return vm.checkMultisig()
+	case OP_CHECKLOCKTIME:
+		item, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		lockTime, ok := bytesNum(item)
+		if !ok {
+			return errors.New("number is not valid")
+		}
+		sameKind := (lockTime < LOCKTIME_THRESHOLD) == (vm.tx.LockTime < LOCKTIME_THRESHOLD)
+		return verify(sameKind && vm.tx.LockTime >= lockTime)
+	}
+	return errors.New("unknown operation")
+}
+
+// Ожидает на стеке подписи, их число m, ключи и их число n. Подписи
+// проверяются по порядку ключей.
+func (vm *machine) checkMultisig() error {
+	item, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	n, ok := bytesNum(item)
+	if !ok || n > MULTISIG_KEYS {
+		return errors.New("number is not valid")
+	}
+	keys, err := vm.popN(int(n))
+	if err != nil {
+		return err
+	}
+	if item, err = vm.pop(); err != nil {
+		return err
+	}
+	m, ok := bytesNum(item)
+	if !ok || m > n {
+		return errors.New("number is not valid")
+	}
+	sigs, err := vm.popN(int(m))
+	if err != nil {
+		return err
+	}
+	k := 0
+	for _, sig := range sigs {
+		found := false
+		for k < len(keys) && !found {
+			if gasCosts[OP_CHECKSIG] > vm.gas {
+				return errors.New("out of gas")
+			}
+			vm.gas -= gasCosts[OP_CHECKSIG]
+			found = vm.checkSig(keys[k], sig)
+			k++
+		}
+		if !found {
+			return vm.push(boolBytes(false))
+		}
+	}
+	return vm.push(boolBytes(true))
+}
+
+func (vm *machine) checkSig(key, sig []byte) bool {
+	pub := ParsePublic(Base64Encode(key))
+	return pub != nil && Verify(pub, vm.tx.CurrHash, sig) == nil
+}
+
+func (vm *machine) executing() bool {
+	for _, branch := range vm.branches {
+		if !branch {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *machine) push(item []byte) error {
+	if len(vm.stack) == SCRIPT_STACK {
+		return errors.New("stack overflow")
+	}
+	if len(item) > SCRIPT_ITEM {
+		return errors.New("item is too large")
+	}
+	vm.stack = append(vm.stack, item)
+	return nil
+}
+
+func (vm *machine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("stack is empty")
+	}
+	item := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return item, nil
+}
+
+// Снимает n элементов и возвращает их в порядке, в котором они
+// были положены.
+func (vm *machine) popN(n int) ([][]byte, error) {
+	if len(vm.stack) < n {
+		return nil, errors.New("stack is empty")
+	}
+	items := make([][]byte, n)
+	copy(items, vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	return items, nil
+}
+
+func verify(ok bool) error {
+	if !ok {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+func isTrue(item []byte) bool {
+	for _, b := range item {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func boolBytes(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return nil
+}
+
+// Числа записываются в big-endian без ведущих нулей, не длиннее 8 байт.
+func numBytes(num uint64) []byte {
+	data := ToBytes(num)
+	for len(data) != 0 && data[0] == 0 {
+		data = data[1:]
+	}
+	return data
+}
+
+func bytesNum(data []byte) (uint64, bool) {
+	if len(data) > 8 {
+		return 0, false
+	}
+	var num uint64
+	for _, b := range data {
+		num = num<<8 | uint64(b)
+	}
+	return num, true
+}
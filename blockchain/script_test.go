@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	var (
+		k1, k2, k3 = NewUser(512), NewUser(512), NewUser(512)
+		secret     = []byte("secret")
+		hashLock   = "0x" + hex.EncodeToString(HashSum(secret))
+		multisig   = "2 " + k1.Address() + " " + k2.Address() + " " + k3.Address() + " 3 CHECKMULTISIG"
+	)
+	sig := func(users ...*User) func(tx *Transaction) [][]byte {
+		return func(tx *Transaction) [][]byte {
+			var witness [][]byte
+			for _, user := range users {
+				witness = append(witness, tx.sign(user.Private()))
+			}
+			return witness
+		}
+	}
+	items := func(items ...[]byte) func(tx *Transaction) [][]byte {
+		return func(tx *Transaction) [][]byte {
+			return items
+		}
+	}
+	tests := []struct {
+		name     string
+		script   string
+		lockTime uint64
+		witness  func(tx *Transaction) [][]byte
+		valid    bool
+	}{
+		{"true", "TRUE", 0, items(), true},
+		{"false", "FALSE", 0, items(), false},
+		{"empty stack", "", 0, items(), false},
+		{"equal", "5 5 EQUAL", 0, items(), true},
+		{"not equal", "5 6 EQUAL", 0, items(), false},
+		{"swap", "FALSE 1 SWAP DROP", 0, items(), true},
+		{"dup", "DUP EQUAL", 0, items([]byte{7}), true},
+		{"drop empty", "DROP TRUE", 0, items(), false},
+		{"verify false", "FALSE VERIFY TRUE", 0, items(), false},
+		{"hash lock", "HASH " + hashLock + " EQUAL", 0, items(secret), true},
+		{"hash lock wrong", "HASH " + hashLock + " EQUAL", 0, items([]byte("wrong")), false},
+		{"checksig", k1.Address() + " CHECKSIG", 0, sig(k1), true},
+		{"checksig other key", k1.Address() + " CHECKSIG", 0, sig(k2), false},
+		{"multisig", multisig, 0, sig(k1, k3), true},
+		{"multisig order", multisig, 0, sig(k3, k1), false},
+		{"multisig short", multisig, 0, sig(k2), false},
+		{"if", "IF TRUE ELSE FALSE ENDIF", 0, items([]byte{1}), true},
+		{"else", "IF TRUE ELSE FALSE ENDIF", 0, items(nil), false},
+		{"nested", "IF IF FALSE ENDIF ELSE TRUE ENDIF", 0, items(nil), true},
+		{"if without endif", "TRUE IF TRUE", 0, items(), false},
+		{"endif without if", "TRUE ENDIF", 0, items(), false},
+		{"locktime", "10 CHECKLOCKTIME TRUE", 10, items(), true},
+		{"locktime early", "10 CHECKLOCKTIME TRUE", 9, items(), false},
+		{"locktime kind", "10 CHECKLOCKTIME TRUE", LOCKTIME_THRESHOLD, items(), false},
+		{"out of gas", "TRUE" + strings.Repeat(" HASH", 100), 0, items(), false},
+	}
+	for _, test := range tests {
+		script, err := ParseScript(test.script)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		tx := NewScriptTransaction(script, &TxParams{
+			Spec:     DefaultSpec(""),
+			LockTime: test.lockTime,
+		}, []Output{{Receiver: "r", Value: 1}}, 1)
+		tx.Witness = test.witness(tx)
+		if err := tx.RunScript(); (err == nil) != test.valid {
+			t.Errorf("%s: RunScript() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{"TRUE dup Equal", true},
+		{"0x00ff 1000", true},
+		{"0xzz", false},
+		{"UNKNOWN!", false},
+		{"0x" + strings.Repeat("00", SCRIPT_ITEM+1), false},
+		{strings.Repeat("TRUE ", SCRIPT_SIZE+1), false},
+	}
+	for _, test := range tests {
+		if _, err := ParseScript(test.text); (err == nil) != test.valid {
+			t.Errorf("ParseScript(%.20q) = %v, valid %v", test.text, err, test.valid)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"database/sql"
 	mrand "math/rand"
 	"time"
 )
@@ -12,51 +11,153 @@ func init() {
 
 const (
 	CREATE_TABLE = `
-CREATE TABLE BlockChain (
+CREATE TABLE IF NOT EXISTS ChainSpec (
+    Id INTEGER PRIMARY KEY,
+    Spec BLOB
+);
+CREATE TABLE IF NOT EXISTS BlockChain (
     Id INTEGER PRIMARY KEY AUTOINCREMENT,
     Hash VARCHAR(44) UNIQUE,
-    Block TEXT
+    Block BLOB
+);
+CREATE TABLE IF NOT EXISTS Blocks (
+    Hash VARCHAR(44) PRIMARY KEY,
+    PrevHash VARCHAR(44),
+    Work TEXT,
+    Block BLOB
+);
+CREATE TABLE IF NOT EXISTS Accounts (
+    Address TEXT,
+    Height INTEGER,
+    Balance INTEGER,
+    Nonce INTEGER,
+    PRIMARY KEY (Address, Height)
 );
+CREATE TABLE IF NOT EXISTS Transactions (
+    Id INTEGER PRIMARY KEY AUTOINCREMENT,
+    Hash VARCHAR(44),
+    Height INTEGER,
+    Position INTEGER,
+    Address TEXT
+);
+CREATE INDEX IF NOT EXISTS TransactionsHash ON Transactions (Hash);
+CREATE INDEX IF NOT EXISTS TransactionsAddress ON Transactions (Address);
 `
 )
 
 const (
-	KEY_SIZE       = 512
-	DEBUG          = true
-	TXS_LIMIT      = 2
-	DIFFICULTY     = 20
-	RAND_BYTES     = 32
-	START_PERCENT  = 10
-	STORAGE_REWARD = 1
-	GENESIS_BLOCK  = "GENESIS-BLOCK"
-	STORAGE_VALUE  = 100
-	GENESIS_REWARD = 100
-	STORAGE_CHAIN  = "STORAGE-CHAIN"
+	DEBUG            = true
+	HISTORY_PAGE     = 10
+	LOCATOR_DENSE    = 10
+	MERKLE_LEAF      = 0x00
+	MERKLE_NODE      = 0x01
+	ENCODING_VERSION = 1
+	STORAGE_CHAIN    = "STORAGE-CHAIN"
+	MULTISIG_PREFIX  = "MULTISIG-"
+	MULTISIG_KEYS    = 16
+	HTLC_PREFIX      = "HTLC-"
+	HTLC_PREIMAGE    = 64
+	MEMO_LIMIT       = 256
+	TOKEN_PREFIX     = "TOKEN-"
+	TOKEN_NAME       = 32
+	SCRIPT_PREFIX    = "SCRIPT-"
+	SCRIPT_SIZE      = 2048
+	SCRIPT_ITEM      = 1024
+	SCRIPT_STACK     = 64
+	SCRIPT_GAS       = 1000
+	// LockTime меньше порога - высота блока, не меньше - время Unix
+	LOCKTIME_THRESHOLD = 500000000
 )
 
 type BlockChain struct {
-	DB *sql.DB
+	Store Store
+	Spec  *ChainSpec
 }
 
 type Block struct {
-	CurrHash     []byte
-	PrevHash     []byte
-	Nonce        uint64
-	Difficulty   uint8
-	Miner        string
-	Signature    []byte
-	TimeStamp    string
+	BlockHeader
+	BlockBody
+}
+
+type BlockHeader struct {
+	ChainID   []byte
+	CurrHash  []byte
+	PrevHash  []byte
+	Nonce     uint64
+	Bits      uint32
+	Miner     string
+	Signature []byte
+	TimeStamp string
+	TxRoot    []byte
+	MapRoot   []byte
+}
+
+type BlockBody struct {
 	Transactions []Transaction
 	Mapping      map[string]uint64
 }
 
+type MerkleProof struct {
+	Hash   []byte
+	Index  uint64
+	Count  uint64
+	Branch [][]byte
+}
+
+type BalanceProof struct {
+	Height    uint64
+	BlockHash []byte
+	Address   string
+	Balance   uint64
+	Proof     *MerkleProof
+}
+
+type Output struct {
+	Receiver string
+	Value    uint64
+}
+
+type TxParams struct {
+	Spec      *ChainSpec
+	ChainID   []byte
+	LastHash  []byte
+	Nonce     uint64
+	LockTime  uint64
+	Data      []byte
+	Encrypted bool
+	Asset     string
+	Token     *Token
+}
+
+type TxRecord struct {
+	Height      uint64
+	Transaction Transaction
+}
+
 type Transaction struct {
-	RandBytes []byte
+	ChainID   []byte
+	Nonce     uint64
 	PrevBlock []byte
 	Sender    string
-	Receiver  string
-	Value     uint64
+	Outputs   []Output
 	ToStorage uint64
+	Fee       uint64
+	LockTime  uint64
+	Data      []byte
+	// Data зашифровано EncryptMemo для получателя
+	Encrypted bool
+	// Идентификатор токена выходов; пустой - монеты
+	Asset     string
+	Token     *Token
 	CurrHash  []byte
 	Signature []byte
+	// Для счетов с несколькими ключами вместо Signature
+	Policy     *Policy
+	Signatures [][]byte
+	// Для адресов условных переводов
+	Contract *Contract
+	Preimage []byte
+	// Для адресов скриптов
+	Script  []byte
+	Witness [][]byte
 }
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Параметры консенсуса сети и начальные балансы. Хеш спецификации
+// записывается в генезис вместо хеша предыдущего блока.
+type ChainSpec struct {
+	Name           string
+	KeySize        uint64
+	TxsLimit       uint64
+	Difficulty     uint64
+	MinDifficulty  uint64
+	BlockInterval  uint64
+	RetargetWindow uint64
+	RetargetLimit  uint64
+	StartPercent   uint64
+	StorageReward  uint64
+	StorageValue   uint64
+	TxWindow       uint64
+	OutputsLimit   uint64
+	Allocations    []Allocation
+}
+
+type Allocation struct {
+	Address string
+	Value   uint64
+}
+
+// Параметры прежних констант. Если receiver не пуст, он получает
+// начальный баланс.
+func DefaultSpec(receiver string) *ChainSpec {
+	spec := &ChainSpec{
+		Name:           "tchain",
+		KeySize:        512,
+		TxsLimit:       2,
+		Difficulty:     20,
+		MinDifficulty:  8,
+		BlockInterval:  20,
+		RetargetWindow: 10,
+		RetargetLimit:  4,
+		StartPercent:   10,
+		StorageReward:  1,
+		StorageValue:   100,
+		TxWindow:       50,
+		OutputsLimit:   16,
+	}
+	if receiver != "" {
+		spec.Allocations = []Allocation{{
+			Address: receiver,
+			Value:   100,
+		}}
+	}
+	return spec
+}
+
+func LoadSpec(filename string) *ChainSpec {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	var spec ChainSpec
+	if json.Unmarshal(data, &spec) != nil {
+		return nil
+	}
+	if !spec.IsValid() {
+		return nil
+	}
+	return &spec
+}
+
+func (spec *ChainSpec) IsValid() bool {
+	switch {
+	case spec == nil:
+		return false
+	case spec.KeySize == 0 || spec.TxsLimit == 0:
+		return false
+	case spec.MinDifficulty == 0 || spec.MinDifficulty > spec.Difficulty || spec.Difficulty >= 256:
+		return false
+	case spec.BlockInterval == 0 || spec.RetargetWindow == 0 || spec.RetargetLimit == 0:
+		return false
+	case spec.TxWindow == 0 || spec.OutputsLimit == 0:
+		return false
+	}
+	addresses := make(map[string]bool)
+	for _, alloc := range spec.Allocations {
+		if alloc.Address == "" || alloc.Address == STORAGE_CHAIN || alloc.Value == 0 {
+			return false
+		}
+		if addresses[alloc.Address] {
+			return false
+		}
+		addresses[alloc.Address] = true
+	}
+	return true
+}
+
+func (spec *ChainSpec) Hash() []byte {
+	return HashSum(EncodeSpec(spec))
+}
+
+// Сбор за хранение, который должна указать транзакция на сумму value
+// по всем получателям.
+func (spec *ChainSpec) StorageFee(value uint64) uint64 {
+	if value > spec.StartPercent {
+		return spec.StorageReward
+	}
+	return 0
+}
@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLiteStore struct {
+	DB    *sql.DB
+	tx    *sql.Tx
+	depth int
+}
+
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func NewSQLiteStore(filename string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", filename+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	store := &SQLiteStore{
+		DB: db,
+	}
+	if err := store.createTables(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *SQLiteStore) Spec() *ChainSpec {
+	var data []byte
+	row := store.conn().QueryRow("SELECT Spec FROM ChainSpec WHERE Id=1")
+	if row.Scan(&data) != nil {
+		return nil
+	}
+	return DecodeSpec(data)
+}
+
+func (store *SQLiteStore) PutSpec(spec *ChainSpec) error {
+	_, err := store.conn().Exec("INSERT OR REPLACE INTO ChainSpec (Id, Spec) VALUES (1, $1)", EncodeSpec(spec))
+	return err
+}
+
+func (store *SQLiteStore) Size() uint64 {
+	var size uint64
+	row := store.conn().QueryRow("SELECT Id FROM BlockChain ORDER BY Id DESC")
+	row.Scan(&size)
+	return size
+}
+
+func (store *SQLiteStore) LastHash() []byte {
+	var hash string
+	row := store.conn().QueryRow("SELECT Hash FROM BlockChain ORDER BY Id DESC")
+	err := row.Scan(&hash)
+	if err != nil {
+		return nil
+	}
+	return Base64Decode(hash)
+}
+
+func (store *SQLiteStore) Block(height uint64) *Block {
+	var sblock []byte
+	row := store.conn().QueryRow("SELECT Block FROM BlockChain WHERE Id=$1", height)
+	if row.Scan(&sblock) != nil {
+		return nil
+	}
+	return DecodeBlock(sblock)
+}
+
+func (store *SQLiteStore) BlockByHash(hash []byte) (*Block, uint64) {
+	var (
+		height uint64
+		sblock []byte
+	)
+	row := store.conn().QueryRow("SELECT Id, Block FROM BlockChain WHERE Hash=$1", Base64Encode(hash))
+	if row.Scan(&height, &sblock) != nil {
+		return nil, 0
+	}
+	return DecodeBlock(sblock), height
+}
+
+func (store *SQLiteStore) PutBlock(block *Block) error {
+	return store.transact(func(view *SQLiteStore) error {
+		res, err := view.tx.Exec("INSERT INTO BlockChain (Id, Hash, Block) VALUES ((SELECT IFNULL(MAX(Id), 0) + 1 FROM BlockChain), $1, $2)",
+			Base64Encode(block.CurrHash),
+			EncodeBlock(block),
+		)
+		if err != nil {
+			return err
+		}
+		height, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		return view.indexBlock(block, uint64(height))
+	})
+}
+
+func (store *SQLiteStore) Truncate(height uint64) error {
+	return store.transact(func(view *SQLiteStore) error {
+		for _, query := range []string{
+			"DELETE FROM BlockChain WHERE Id > $1",
+			"DELETE FROM Accounts WHERE Height > $1",
+			"DELETE FROM Transactions WHERE Height > $1",
+		} {
+			if _, err := view.tx.Exec(query, height); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *SQLiteStore) SaveBlock(block *Block, work *big.Int) error {
+	_, err := store.conn().Exec("INSERT OR REPLACE INTO Blocks (Hash, PrevHash, Work, Block) VALUES ($1, $2, $3, $4)",
+		Base64Encode(block.CurrHash),
+		Base64Encode(block.PrevHash),
+		work.String(),
+		EncodeBlock(block),
+	)
+	return err
+}
+
+func (store *SQLiteStore) LoadBlock(hash []byte) (*Block, *big.Int) {
+	var (
+		swork  string
+		sblock []byte
+	)
+	row := store.conn().QueryRow("SELECT Work, Block FROM Blocks WHERE Hash=$1", Base64Encode(hash))
+	if row.Scan(&swork, &sblock) != nil {
+		return nil, nil
+	}
+	work, ok := new(big.Int).SetString(swork, 10)
+	if !ok {
+		return nil, nil
+	}
+	return DecodeBlock(sblock), work
+}
+
+func (store *SQLiteStore) RemoveBlock(hash []byte) error {
+	_, err := store.conn().Exec("DELETE FROM Blocks WHERE Hash=$1", Base64Encode(hash))
+	return err
+}
+
+func (store *SQLiteStore) Balance(address string, height uint64) uint64 {
+	var balance uint64
+	row := store.conn().QueryRow("SELECT Balance FROM Accounts WHERE Address=$1 AND Height<=$2 ORDER BY Height DESC LIMIT 1",
+		address, height)
+	row.Scan(&balance)
+	return balance
+}
+
+func (store *SQLiteStore) BalanceHeight(address string, height uint64) uint64 {
+	var last uint64
+	row := store.conn().QueryRow("SELECT Height FROM Accounts WHERE Address=$1 AND Height<=$2 ORDER BY Height DESC LIMIT 1",
+		address, height)
+	row.Scan(&last)
+	return last
+}
+
+func (store *SQLiteStore) Nonce(address string, height uint64) uint64 {
+	var nonce uint64
+	row := store.conn().QueryRow("SELECT Nonce FROM Accounts WHERE Address=$1 AND Height<=$2 ORDER BY Height DESC LIMIT 1",
+		address, height)
+	row.Scan(&nonce)
+	return nonce
+}
+
+func (store *SQLiteStore) Transaction(hash []byte) (*Transaction, uint64) {
+	var height, position uint64
+	row := store.conn().QueryRow("SELECT Height, Position FROM Transactions WHERE Hash=$1 ORDER BY Id ASC LIMIT 1",
+		Base64Encode(hash))
+	if row.Scan(&height, &position) != nil {
+		return nil, 0
+	}
+	block := store.Block(height)
+	if block == nil || position >= uint64(len(block.Transactions)) {
+		return nil, 0
+	}
+	return &block.Transactions[position], height
+}
+
+func (store *SQLiteStore) History(address string, offset, limit uint64) []TxRecord {
+	var (
+		records   []TxRecord
+		heights   []uint64
+		positions []uint64
+	)
+	rows, err := store.conn().Query("SELECT Height, Position FROM Transactions WHERE Address=$1 ORDER BY Id DESC LIMIT $2 OFFSET $3",
+		address, limit, offset)
+	if err != nil {
+		return nil
+	}
+	for rows.Next() {
+		var height, position uint64
+		rows.Scan(&height, &position)
+		heights = append(heights, height)
+		positions = append(positions, position)
+	}
+	rows.Close()
+	var block *Block
+	for i, height := range heights {
+		if block == nil || i == 0 || heights[i-1] != height {
+			block = store.Block(height)
+		}
+		if block == nil || positions[i] >= uint64(len(block.Transactions)) {
+			return nil
+		}
+		records = append(records, TxRecord{
+			Height:      height,
+			Transaction: block.Transactions[positions[i]],
+		})
+	}
+	return records
+}
+
+func (store *SQLiteStore) Update(fn func(Store) error) error {
+	return store.transact(func(view *SQLiteStore) error {
+		return fn(view)
+	})
+}
+
+func (store *SQLiteStore) Close() error {
+	if store.tx != nil {
+		return nil
+	}
+	return store.DB.Close()
+}
+
+func (store *SQLiteStore) conn() querier {
+	if store.tx != nil {
+		return store.tx
+	}
+	return store.DB
+}
+
+// Выполняет fn в транзакции БД, а внутри уже открытой транзакции
+// использует точку сохранения, чтобы откатить только изменения fn.
+func (store *SQLiteStore) transact(fn func(view *SQLiteStore) error) error {
+	view := &SQLiteStore{
+		DB:    store.DB,
+		tx:    store.tx,
+		depth: store.depth + 1,
+	}
+	if store.tx != nil {
+		savepoint := fmt.Sprintf("SAVEPOINT_%d", view.depth)
+		if _, err := store.tx.Exec("SAVEPOINT " + savepoint); err != nil {
+			return err
+		}
+		if err := fn(view); err != nil {
+			store.tx.Exec("ROLLBACK TO " + savepoint)
+			store.tx.Exec("RELEASE " + savepoint)
+			return err
+		}
+		_, err := store.tx.Exec("RELEASE " + savepoint)
+		return err
+	}
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	view.tx = tx
+	if err := fn(view); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) indexBlock(block *Block, height uint64) error {
+	nonces := block.nonces()
+	for addr, value := range block.Mapping {
+		nonce, ok := nonces[addr]
+		if !ok {
+			nonce = store.Nonce(addr, height-1)
+		}
+		_, err := store.conn().Exec("INSERT OR REPLACE INTO Accounts (Address, Height, Balance, Nonce) VALUES ($1, $2, $3, $4)",
+			addr, height, value, nonce)
+		if err != nil {
+			return err
+		}
+	}
+	for i, tx := range block.Transactions {
+		for _, addr := range tx.addresses() {
+			_, err := store.conn().Exec("INSERT INTO Transactions (Hash, Height, Position, Address) VALUES ($1, $2, $3, $4)",
+				Base64Encode(tx.CurrHash), height, i, addr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Создает таблицы новой базы. Базы, созданные до ChainSpec, двоичного
+// кодирования и номеров счетов, не переносятся: их блоки в JSON, а
+// состояние не восстановить без спецификации цепочки.
+func (store *SQLiteStore) createTables() error {
+	var chain, tables, nonce uint64
+	row := store.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='BlockChain'")
+	if err := row.Scan(&chain); err != nil {
+		return err
+	}
+	row = store.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name IN ('ChainSpec', 'Blocks', 'Transactions')")
+	if err := row.Scan(&tables); err != nil {
+		return err
+	}
+	row = store.DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('Accounts') WHERE name='Nonce'")
+	if err := row.Scan(&nonce); err != nil {
+		return err
+	}
+	if chain != 0 && (tables != 3 || nonce != 1) {
+		return ErrOldDatabase
+	}
+	_, err := store.DB.Exec(CREATE_TABLE)
+	return err
+}
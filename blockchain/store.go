@@ -0,0 +1,24 @@
+package blockchain
+
+import "math/big"
+
+type Store interface {
+	Spec() *ChainSpec
+	PutSpec(spec *ChainSpec) error
+	Size() uint64
+	LastHash() []byte
+	Block(height uint64) *Block
+	BlockByHash(hash []byte) (*Block, uint64)
+	PutBlock(block *Block) error
+	Truncate(height uint64) error
+	SaveBlock(block *Block, work *big.Int) error
+	LoadBlock(hash []byte) (*Block, *big.Int)
+	RemoveBlock(hash []byte) error
+	Balance(address string, height uint64) uint64
+	BalanceHeight(address string, height uint64) uint64
+	Nonce(address string, height uint64) uint64
+	Transaction(hash []byte) (*Transaction, uint64)
+	History(address string, offset, limit uint64) []TxRecord
+	Update(fn func(Store) error) error
+	Close() error
+}
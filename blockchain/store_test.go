@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Реализации хранилища, которые должны вести себя одинаково.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestStore(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+	)
+	for name, store := range testStores(t) {
+		var (
+			chain  = testChain(t, store, testSpec(user))
+			tx1    = NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 5}}, 1)
+			block1 = testMine(t, chain, miner, tx1)
+			tx2    = NewTransaction(user, chain.TxParams(user.Address()), []Output{{Receiver: miner.Address(), Value: 20}}, 2)
+			block2 = testMine(t, chain, miner, tx2)
+		)
+		if chain.Spec.Name != store.Spec().Name {
+			t.Errorf("%s: spec is not stored", name)
+		}
+		if size := store.Size(); size != 3 {
+			t.Fatalf("%s: Size() = %d, want 3", name, size)
+		}
+		if !bytes.Equal(store.LastHash(), block2.CurrHash) {
+			t.Errorf("%s: LastHash() is not the last block", name)
+		}
+		if block := store.Block(2); block == nil || !bytes.Equal(block.CurrHash, block1.CurrHash) {
+			t.Errorf("%s: Block(2) is not the first mined block", name)
+		}
+		if _, height := store.BlockByHash(block2.CurrHash); height != 3 {
+			t.Errorf("%s: BlockByHash() height = %d, want 3", name, height)
+		}
+		balances := []struct {
+			address string
+			height  uint64
+			balance uint64
+			nonce   uint64
+		}{
+			{user.Address(), 1, 100, 0},
+			{user.Address(), 2, 94, 1},
+			{user.Address(), 3, 71, 2},
+			{miner.Address(), 1, 0, 0},
+			{miner.Address(), 3, 30, 0},
+			{STORAGE_CHAIN, 3, 99, 0},
+		}
+		for _, b := range balances {
+			if balance := store.Balance(b.address, b.height); balance != b.balance {
+				t.Errorf("%s: Balance(%.8s, %d) = %d, want %d", name, b.address, b.height, balance, b.balance)
+			}
+			if nonce := store.Nonce(b.address, b.height); nonce != b.nonce {
+				t.Errorf("%s: Nonce(%.8s, %d) = %d, want %d", name, b.address, b.height, nonce, b.nonce)
+			}
+		}
+		if height := store.BalanceHeight(user.Address(), 3); height != 3 {
+			t.Errorf("%s: BalanceHeight() = %d, want 3", name, height)
+		}
+		if _, height := store.Transaction(tx1.CurrHash); height != 2 {
+			t.Errorf("%s: Transaction() height = %d, want 2", name, height)
+		}
+		if records := store.History(user.Address(), 0, 10); len(records) != 2 || records[0].Height != 3 {
+			t.Errorf("%s: History() = %d records, want 2 newest first", name, len(records))
+		}
+		if records := store.History(user.Address(), 1, 10); len(records) != 1 || records[0].Height != 2 {
+			t.Errorf("%s: History() offset is not applied", name)
+		}
+		failed := errors.New("failed")
+		err := store.Update(func(store Store) error {
+			if err := store.Truncate(1); err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed || store.Size() != 3 {
+			t.Errorf("%s: failed Update() changed the store", name)
+		}
+		if err := store.Truncate(2); err != nil {
+			t.Fatal(err)
+		}
+		if size := store.Size(); size != 2 {
+			t.Errorf("%s: Size() after Truncate(2) = %d, want 2", name, size)
+		}
+		if tx, _ := store.Transaction(tx2.CurrHash); tx != nil {
+			t.Errorf("%s: truncated transaction is found", name)
+		}
+		if nonce := store.Nonce(user.Address(), 3); nonce != 1 {
+			t.Errorf("%s: Nonce() after Truncate(2) = %d, want 1", name, nonce)
+		}
+		if balance := store.Balance(user.Address(), 3); balance != 94 {
+			t.Errorf("%s: Balance() after Truncate(2) = %d, want 94", name, balance)
+		}
+		if block, _ := store.LoadBlock(block2.CurrHash); block == nil {
+			t.Errorf("%s: truncated block is not kept as a side block", name)
+		}
+	}
+}
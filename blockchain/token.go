@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"strings"
+)
+
+// Токен, выпущенный пользователем. Идентификатор токена - хеш его
+// описания; Nonce - номер транзакции выпуска у Issuer, поэтому токен
+// с фиксированным объемом выпускается ровно один раз. Токен с Mintable
+// Issuer может довыпускать последующими транзакциями.
+type Token struct {
+	Issuer   string
+	Name     string
+	Mintable bool
+	Nonce    uint64
+}
+
+func (token *Token) IsValid() bool {
+	switch {
+	case token == nil:
+		return false
+	case token.Issuer == "" || IsToken(token.Issuer):
+		return false
+	case token.Name == "" || len(token.Name) > TOKEN_NAME:
+		return false
+	}
+	return true
+}
+
+func (token *Token) ID() string {
+	return Base64Encode(HashSum(EncodeToken(token)))
+}
+
+// Счет, на котором хранится баланс адреса в токене asset. Балансы
+// токенов лежат в Mapping блока рядом с балансами монет.
+func TokenAccount(asset, address string) string {
+	return TOKEN_PREFIX + asset + ":" + address
+}
+
+func IsToken(account string) bool {
+	return strings.HasPrefix(account, TOKEN_PREFIX)
+}
+
+// Транзакция с Token выпускает выходы в токене, остальные транзакции
+// с Asset переводят токен отправителя. Сборы в обоих случаях платятся
+// монетами.
+func (tx *Transaction) assetIsValid() bool {
+	if tx.Asset == "" {
+		return tx.Token == nil
+	}
+	if Base64Encode(Base64Decode(tx.Asset)) != tx.Asset || len(Base64Decode(tx.Asset)) != len(HashSum(nil)) {
+		return false
+	}
+	if tx.Token == nil {
+		return true
+	}
+	switch {
+	case !tx.Token.IsValid():
+		return false
+	case tx.Token.ID() != tx.Asset:
+		return false
+	case tx.Token.Issuer != tx.Sender:
+		return false
+	case tx.Nonce == tx.Token.Nonce:
+		return true
+	}
+	return tx.Token.Mintable && tx.Nonce > tx.Token.Nonce
+}
+
+// Счет, на который зачисляется выход.
+func (tx *Transaction) outputAccount(receiver string) string {
+	if tx.Asset == "" {
+		return receiver
+	}
+	return TokenAccount(tx.Asset, receiver)
+}
+
+// Счета, балансы которых меняет транзакция, кроме хранилища и майнера.
+func (tx *Transaction) Accounts() []string {
+	var (
+		list = []string{tx.Sender}
+		seen = map[string]bool{tx.Sender: true}
+	)
+	if tx.Asset != "" && tx.Token == nil {
+		account := TokenAccount(tx.Asset, tx.Sender)
+		seen[account] = true
+		list = append(list, account)
+	}
+	for _, output := range tx.Outputs {
+		account := tx.outputAccount(output.Receiver)
+		if !seen[account] {
+			seen[account] = true
+			list = append(list, account)
+		}
+	}
+	return list
+}
+
+// Сумма, списываемая транзакцией со счета account.
+func (tx *Transaction) Debit(account string) uint64 {
+	switch {
+	case account == tx.Sender && tx.Asset == "":
+		total, _ := tx.Total()
+		return total
+	case account == tx.Sender:
+		return tx.ToStorage + tx.Fee
+	case tx.Asset != "" && tx.Token == nil && account == TokenAccount(tx.Asset, tx.Sender):
+		amount, _ := tx.Amount()
+		return amount
+	}
+	return 0
+}
+
+// Сумма выходов, зачисляемая на счет account.
+func (tx *Transaction) credit(account string) uint64 {
+	var value uint64
+	for _, output := range tx.Outputs {
+		if tx.outputAccount(output.Receiver) == account {
+			value += output.Value
+		}
+	}
+	return value
+}
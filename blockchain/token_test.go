@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		alice = NewUser(512)
+		bob   = NewUser(512)
+		chain = testChain(t, NewMemoryStore(), testSpec(alice, bob))
+		fixed = &Token{Issuer: alice.Address(), Name: "fixed", Nonce: 0}
+	)
+	send := func(user *User, token *Token, asset string, receiver *User, value uint64) *Transaction {
+		params := chain.TxParams(user.Address())
+		params.Asset, params.Token = asset, token
+		return NewTransaction(user, params, []Output{{Receiver: receiver.Address(), Value: value}}, 0)
+	}
+	balance := func(asset string, user *User) uint64 {
+		return chain.Balance(TokenAccount(asset, user.Address()), chain.Size())
+	}
+	testMine(t, chain, miner, send(alice, fixed, fixed.ID(), alice, 1000))
+	if value := balance(fixed.ID(), alice); value != 1000 {
+		t.Fatalf("issued balance = %d, want 1000", value)
+	}
+	if value := chain.Balance(alice.Address(), chain.Size()); value != 99 {
+		t.Errorf("issuer coin balance = %d, want 99", value)
+	}
+	testMine(t, chain, miner, send(alice, nil, fixed.ID(), bob, 300))
+	if value := balance(fixed.ID(), alice); value != 700 {
+		t.Errorf("sender token balance = %d, want 700", value)
+	}
+	if value := balance(fixed.ID(), bob); value != 300 {
+		t.Errorf("receiver token balance = %d, want 300", value)
+	}
+	if value := chain.Balance(bob.Address(), chain.Size()); value != 100 {
+		t.Errorf("receiver coin balance = %d, want 100", value)
+	}
+	mintable := &Token{Issuer: alice.Address(), Name: "mintable", Mintable: true, Nonce: chain.Nonce(alice.Address(), chain.Size())}
+	testMine(t, chain, miner, send(alice, mintable, mintable.ID(), alice, 10))
+	foreign := &Token{Issuer: alice.Address(), Name: "foreign", Nonce: chain.Nonce(bob.Address(), chain.Size())}
+	tests := []struct {
+		name  string
+		tx    *Transaction
+		valid bool
+	}{
+		{"transfer", send(bob, nil, fixed.ID(), alice, 300), true},
+		{"transfer over balance", send(bob, nil, fixed.ID(), alice, 301), false},
+		{"transfer of unknown token", send(bob, nil, Base64Encode(HashSum(nil)), alice, 1), false},
+		{"reissue fixed", send(alice, fixed, fixed.ID(), alice, 1), false},
+		{"mint", send(alice, mintable, mintable.ID(), bob, 5), true},
+		{"mint by other user", send(bob, mintable, mintable.ID(), bob, 5), false},
+		{"issue for other user", send(bob, foreign, foreign.ID(), bob, 5), false},
+		{"wrong asset", send(alice, mintable, fixed.ID(), alice, 5), false},
+	}
+	for _, test := range tests {
+		block := testBlock(t, chain, miner, NewTransaction(miner, chain.TxParams(miner.Address()), []Output{{Receiver: alice.Address(), Value: 1}}, 0))
+		block.Transactions[0] = *test.tx
+		testReseal(chain, miner, block)
+		if err := testAccepts(chain, block); (err == nil) != test.valid {
+			t.Errorf("%s: AcceptBlock() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+}
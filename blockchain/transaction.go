@@ -3,36 +3,82 @@ package blockchain
 import (
 	"bytes"
 	"crypto/rsa"
+	"time"
 )
 
-func NewTransaction(user *User, lasthash []byte, to string, value uint64) *Transaction {
+// Все выходы подписываются одной подписью отправителя. Комиссия fee
+// достается майнеру блока, в который войдет транзакция.
+func NewTransaction(user *User, params *TxParams, outputs []Output, fee uint64) *Transaction {
+	tx := newTx(user.Address(), params, outputs, fee)
+	tx.Signature = tx.sign(user.Private())
+	return tx
+}
+
+// Хешированная транзакция без подписи. Конструкторы особых счетов
+// добавляют к ней только свои неподписываемые поля.
+func newTx(sender string, params *TxParams, outputs []Output, fee uint64) *Transaction {
 	tx := &Transaction{
-		RandBytes: GenerateRandomBytes(RAND_BYTES),
-		PrevBlock: lasthash,
-		Sender:    user.Address(),
-		Receiver:  to,
-		Value:     value,
+		ChainID:   params.ChainID,
+		Nonce:     params.Nonce,
+		PrevBlock: params.LastHash,
+		Sender:    sender,
+		Outputs:   outputs,
+		Fee:       fee,
+		LockTime:  params.LockTime,
+		Data:      params.Data,
+		Encrypted: params.Encrypted,
+		Asset:     params.Asset,
+		Token:     params.Token,
 	}
-	if value > START_PERCENT {
-		tx.ToStorage = STORAGE_REWARD
+	if amount, ok := tx.Amount(); ok {
+		tx.ToStorage = params.Spec.StorageFee(amount)
 	}
 	tx.CurrHash = tx.hash()
-	tx.Signature = tx.sign(user.Private())
 	return tx
 }
 
+// Сумма выходов и false при переполнении.
+func (tx *Transaction) Amount() (uint64, bool) {
+	var amount uint64
+	for _, output := range tx.Outputs {
+		if amount+output.Value < amount {
+			return 0, false
+		}
+		amount += output.Value
+	}
+	return amount, true
+}
+
+// Сумма, списываемая с отправителя, и false при переполнении.
+func (tx *Transaction) Total() (uint64, bool) {
+	total, ok := tx.Amount()
+	if !ok {
+		return 0, false
+	}
+	for _, num := range []uint64{tx.ToStorage, tx.Fee} {
+		if total+num < total {
+			return 0, false
+		}
+		total += num
+	}
+	return total, true
+}
+
+// Сумма выходов в пользу address.
+func (tx *Transaction) Received(address string) uint64 {
+	var value uint64
+	for _, output := range tx.Outputs {
+		if output.Receiver == address {
+			value += output.Value
+		}
+	}
+	return value
+}
+
 func (tx *Transaction) hash() []byte {
-	return HashSum(bytes.Join(
-		[][]byte{
-			tx.RandBytes,
-			tx.PrevBlock,
-			[]byte(tx.Sender),
-			[]byte(tx.Receiver),
-			ToBytes(tx.Value),
-			ToBytes(tx.ToStorage),
-		},
-		[]byte{},
-	))
+	enc := newEncoder()
+	tx.encodeSigned(enc)
+	return HashSum(enc.bytes())
 }
 
 func (tx *Transaction) sign(priv *rsa.PrivateKey) []byte {
@@ -44,5 +90,125 @@ func (tx *Transaction) hashIsValid() bool {
 }
 
 func (tx *Transaction) signIsValid() bool {
-	return Verify(ParsePublic(tx.Sender), tx.CurrHash, tx.Signature) == nil
+	if IsMultisig(tx.Sender) {
+		return tx.multisigIsValid()
+	}
+	if IsHTLC(tx.Sender) {
+		return tx.htlcIsValid()
+	}
+	if IsScript(tx.Sender) {
+		return tx.scriptIsValid()
+	}
+	pub := ParsePublic(tx.Sender)
+	return pub != nil && Verify(pub, tx.CurrHash, tx.Signature) == nil
+}
+
+// Проверяет число и суммы выходов и сбор за хранение с их общей суммы.
+func (tx *Transaction) outputsIsValid(spec *ChainSpec) bool {
+	if len(tx.Outputs) == 0 || uint64(len(tx.Outputs)) > spec.OutputsLimit {
+		return false
+	}
+	for _, output := range tx.Outputs {
+		if output.Receiver == "" || output.Value == 0 || IsToken(output.Receiver) {
+			return false
+		}
+	}
+	amount, ok := tx.Amount()
+	if !ok {
+		return false
+	}
+	if _, ok := tx.Total(); !ok {
+		return false
+	}
+	return amount <= spec.StartPercent || tx.ToStorage == spec.StorageReward
+}
+
+// Отправитель и получатели без повторов.
+func (tx *Transaction) addresses() []string {
+	var (
+		list = []string{tx.Sender}
+		seen = map[string]bool{tx.Sender: true}
+	)
+	for _, output := range tx.Outputs {
+		if !seen[output.Receiver] {
+			seen[output.Receiver] = true
+			list = append(list, output.Receiver)
+		}
+	}
+	return list
+}
+
+// Проверки, не зависящие от баланса и номера транзакции отправителя.
+// Транзакция со старой или отключенной ссылкой PrevBlock недействительна.
+func (tx *Transaction) IsValid(chain *BlockChain) bool {
+	switch {
+	case tx == nil || tx.Sender == STORAGE_CHAIN:
+		return false
+	case !tx.outputsIsValid(chain.Spec):
+		return false
+	case !tx.memoIsValid(chain.Spec):
+		return false
+	case !tx.assetIsValid():
+		return false
+	case !bytes.Equal(tx.ChainID, chain.ID()):
+		return false
+	case !tx.anchorIsValid(chain):
+		return false
+	case !tx.hashIsValid():
+		return false
+	case !tx.signIsValid():
+		return false
+	}
+	return true
+}
+
+// Размер memo без накладных расходов шифрования: MEMO_LIMIT ограничивает
+// текст, поэтому шифрование доступно при любом KeySize цепочки.
+func (tx *Transaction) MemoSize(spec *ChainSpec) uint64 {
+	size := uint64(len(tx.Data))
+	if overhead := MemoOverhead(spec.KeySize); tx.Encrypted && size >= overhead {
+		size -= overhead
+	}
+	return size
+}
+
+func (tx *Transaction) memoIsValid(spec *ChainSpec) bool {
+	if tx.Encrypted && uint64(len(tx.Data)) < MemoOverhead(spec.KeySize) {
+		return false
+	}
+	return tx.MemoSize(spec) <= MEMO_LIMIT
+}
+
+// Может ли транзакция войти в блок на высоте height со временем btime.
+func (tx *Transaction) IsFinal(height uint64, btime time.Time) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LOCKTIME_THRESHOLD:
+		return height >= tx.LockTime
+	}
+	return btime.Unix() >= int64(tx.LockTime)
+}
+
+// Окно TxWindow заблокированной транзакции отсчитывается от высоты,
+// на которой она становится действительной, если та позже PrevBlock:
+// иначе отложенный платеж устаревал бы раньше, чем становился
+// действительным. Для блокировки по времени это первый блок с
+// TimeStamp не раньше LockTime.
+func (tx *Transaction) anchorIsValid(chain *BlockChain) bool {
+	if tx.LockTime == 0 {
+		return chain.AnchorIsValid(tx.PrevBlock)
+	}
+	_, height := chain.BlockByHash(tx.PrevBlock)
+	if height == 0 {
+		return false
+	}
+	lock := tx.LockTime
+	if lock >= LOCKTIME_THRESHOLD {
+		lock = chain.heightAt(time.Unix(int64(tx.LockTime), 0))
+	}
+	if lock > height {
+		height = lock
+	}
+	return chain.Size() < height+chain.Spec.TxWindow
 }
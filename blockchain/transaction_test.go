@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestNonce(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		chain = testChain(t, NewMemoryStore(), testSpec(user))
+		pay   = func(nonce uint64) *Transaction {
+			params := chain.TxParams(user.Address())
+			params.Nonce = nonce
+			return NewTransaction(user, params, []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+		}
+	)
+	first := pay(0)
+	testMine(t, chain, miner, first)
+	if nonce := chain.TxParams(user.Address()).Nonce; nonce != 1 {
+		t.Fatalf("TxParams().Nonce = %d, want 1", nonce)
+	}
+	tests := []struct {
+		name string
+		txs  []*Transaction
+	}{
+		{"replay", []*Transaction{first}},
+		{"old nonce", []*Transaction{pay(0)}},
+		{"gap", []*Transaction{pay(2)}},
+		{"reordered", []*Transaction{pay(2), pay(1)}},
+		{"repeated", []*Transaction{pay(1), pay(1)}},
+	}
+	for _, test := range tests {
+		block := NewBlock(miner.Address(), chain.LastHash())
+		for _, tx := range test.txs {
+			block.AddTransaction(chain, tx)
+		}
+		if len(block.Transactions) == len(test.txs) {
+			t.Errorf("%s: AddTransaction() accepted all transactions", test.name)
+		}
+		// Блок, собранный в обход AddTransaction, тоже отклоняется.
+		block = testBlock(t, chain, miner, pay(1))
+		coinbase := block.Transactions[len(block.Transactions)-1]
+		block.Transactions = append(derefs(test.txs), coinbase)
+		testReseal(chain, miner, block)
+		if _, err := chain.AcceptBlock(block); err == nil {
+			t.Errorf("%s: AcceptBlock() accepted the block", test.name)
+		}
+	}
+	testMine(t, chain, miner, pay(1), pay(2))
+	if nonce := chain.Nonce(user.Address(), chain.Size()); nonce != 3 {
+		t.Errorf("Nonce() = %d, want 3", nonce)
+	}
+}
+
+func derefs(txs []*Transaction) []Transaction {
+	list := make([]Transaction, len(txs))
+	for i, tx := range txs {
+		list[i] = *tx
+	}
+	return list
+}
+
+func TestAnchor(t *testing.T) {
+	var (
+		miner = NewUser(512)
+		user  = NewUser(512)
+		other = NewUser(512)
+		spec  = testSpec(user, other)
+	)
+	spec.TxWindow = 2
+	chain := testChain(t, NewMemoryStore(), spec)
+	pay := func(anchor []byte) *Transaction {
+		params := chain.TxParams(user.Address())
+		params.LastHash = anchor
+		return NewTransaction(user, params, []Output{{Receiver: miner.Address(), Value: 1}}, 0)
+	}
+	genesis := chain.LastHash()
+	for i := 0; i < 2; i++ {
+		if !pay(genesis).anchorIsValid(chain) {
+			t.Fatalf("anchor is expired %d blocks after genesis", i)
+		}
+		testMine(t, chain, miner, NewTransaction(other, chain.TxParams(other.Address()), []Output{{Receiver: miner.Address(), Value: 1}}, 0))
+	}
+	tests := []struct {
+		name   string
+		anchor []byte
+		valid  bool
+	}{
+		{"tip", chain.LastHash(), true},
+		{"last in window", chain.Block(chain.Size() - 1).CurrHash, true},
+		{"expired", genesis, false},
+		{"unknown", HashSum(nil), false},
+	}
+	for _, test := range tests {
+		tx := pay(test.anchor)
+		block := NewBlock(miner.Address(), chain.LastHash())
+		if err := block.AddTransaction(chain, tx); (err == nil) != test.valid {
+			t.Errorf("%s: AddTransaction() = %v, valid %v", test.name, err, test.valid)
+		}
+		block = testBlock(t, chain, miner, pay(chain.LastHash()))
+		block.Transactions[0] = *tx
+		testReseal(chain, miner, block)
+		if err := testAccepts(chain, block); (err == nil) != test.valid {
+			t.Errorf("%s: AcceptBlock() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+}
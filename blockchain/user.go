@@ -6,9 +6,9 @@ type User struct {
 	PrivateKey *rsa.PrivateKey
 }
 
-func NewUser() *User {
+func NewUser(bits uint64) *User {
 	return &User{
-		PrivateKey: GeneratePrivate(KEY_SIZE),
+		PrivateKey: GeneratePrivate(uint(bits)),
 	}
 }
 
@@ -3,35 +3,74 @@ package blockchain
 import "encoding/json"
 
 func SerializeBlock(block *Block) string {
-	jsonData, err := json.MarshalIndent(*block, "", "\t")
-	if err != nil {
-		return ""
-	}
-	return string(jsonData)
+	return Base64Encode(EncodeBlock(block))
 }
 
 func DeserializeBlock(data string) *Block {
-	var block Block
-	err := json.Unmarshal([]byte(data), &block)
-	if err != nil {
-		return nil
-	}
-	return &block
+	return DecodeBlock(Base64Decode(data))
+}
+
+func SerializeHeader(header *BlockHeader) string {
+	return Base64Encode(EncodeHeader(header))
+}
+
+func DeserializeHeader(data string) *BlockHeader {
+	return DecodeHeader(Base64Decode(data))
+}
+
+func SerializeBody(body *BlockBody) string {
+	return Base64Encode(EncodeBody(body))
+}
+
+func DeserializeBody(data string) *BlockBody {
+	return DecodeBody(Base64Decode(data))
 }
 
 func SerializeTX(tx *Transaction) string {
-	jsonData, err := json.MarshalIndent(*tx, "", "\t")
-	if err != nil {
-		return ""
-	}
-	return string(jsonData)
+	return Base64Encode(EncodeTX(tx))
 }
 
 func DeserializeTX(data string) *Transaction {
-	var tx Transaction
-	err := json.Unmarshal([]byte(data), &tx)
+	return DecodeTX(Base64Decode(data))
+}
+
+func SerializeRecords(records []TxRecord) string {
+	return Base64Encode(EncodeRecords(records))
+}
+
+func DeserializeRecords(data string) []TxRecord {
+	return DecodeRecords(Base64Decode(data))
+}
+
+func SerializeProof(proof *MerkleProof) string {
+	return Base64Encode(EncodeProof(proof))
+}
+
+func DeserializeProof(data string) *MerkleProof {
+	return DecodeProof(Base64Decode(data))
+}
+
+func SerializeBalanceProof(proof *BalanceProof) string {
+	return Base64Encode(EncodeBalanceProof(proof))
+}
+
+func DeserializeBalanceProof(data string) *BalanceProof {
+	return DecodeBalanceProof(Base64Decode(data))
+}
+
+func SerializeSpec(spec *ChainSpec) string {
+	return Base64Encode(EncodeSpec(spec))
+}
+
+func DeserializeSpec(data string) *ChainSpec {
+	return DecodeSpec(Base64Decode(data))
+}
+
+// JSON только для вывода и отладки, в хешах и протоколе не используется.
+func ToJSON(value interface{}) string {
+	jsonData, err := json.MarshalIndent(value, "", "\t")
 	if err != nil {
-		return nil
+		return ""
 	}
-	return &tx
+	return string(jsonData)
 }
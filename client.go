@@ -2,13 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	bc "tchain/blockchain"
 	nt "tchain/network"
+	"time"
+)
+
+var (
+	Light   bool
+	Spec    *bc.ChainSpec
+	Headers *bc.HeaderChain
 )
 
 func init() {
@@ -19,6 +31,7 @@ func init() {
 		addrStr     = ""
 		userNewStr  = ""
 		userLoadStr = ""
+		chainIDStr  = ""
 	)
 	var (
 		addrExist     = false
@@ -38,6 +51,10 @@ func init() {
 		case strings.HasPrefix(arg, "-loaduser:"):
 			userLoadStr = strings.Replace(arg, "-loaduser:", "", 1)
 			userLoadExist = true
+		case strings.HasPrefix(arg, "-chainid:"):
+			chainIDStr = strings.Replace(arg, "-chainid:", "", 1)
+		case arg == "-light":
+			Light = true
 		}
 	}
 	if !(userNewExist || userLoadExist) || !addrExist {
@@ -50,8 +67,21 @@ func init() {
 	if len(Addresses) == 0 {
 		panic("failed: len(Addresses) == 0")
 	}
+	var chainID []byte
+	if chainIDStr != "" {
+		if chainID = bc.Base64Decode(chainIDStr); len(chainID) == 0 {
+			panic("failed: chain id")
+		}
+	}
+	Headers, err = loadGenesis(Addresses, chainID)
+	if err != nil {
+		fmt.Println("failed: load genesis:", err)
+		os.Exit(1)
+	}
+	Spec = Headers.Spec
+	Addresses = checkPeers(Addresses, Headers.ID())
 	if userNewExist {
-		User = userNew(userNewStr)
+		User = userNew(userNewStr, Spec.KeySize)
 	}
 	if userLoadExist {
 		User = userLoad(userLoadStr)
@@ -65,6 +95,60 @@ func main() {
 	handleClient()
 }
 
+// Генезис, на котором сходится большинство ответивших узлов, или
+// генезис с идентификатором chainID, если он закреплен флагом -chainid.
+// Клиент не доверяет первому узлу из списка.
+func loadGenesis(addresses []string, chainID []byte) (*bc.HeaderChain, error) {
+	var (
+		chains  = make(map[string]*bc.HeaderChain)
+		votes   = make(map[string]int)
+		answers = 0
+	)
+	for _, addr := range addresses {
+		headers := genesis(addr)
+		if headers == nil {
+			continue
+		}
+		if chainID != nil && bytes.Equal(headers.ID(), chainID) {
+			return headers, nil
+		}
+		id := bc.Base64Encode(headers.ID())
+		chains[id] = headers
+		votes[id]++
+		answers++
+	}
+	switch {
+	case answers == 0:
+		return nil, errors.New("no node answered")
+	case chainID != nil:
+		return nil, errors.New("no node on the pinned chain")
+	}
+	for id, count := range votes {
+		if 2*count > answers {
+			return chains[id], nil
+		}
+	}
+	return nil, errors.New("nodes do not agree on the chain")
+}
+
+func genesis(addr string) *bc.HeaderChain {
+	res := nt.Send(addr, &nt.Package{
+		Option: GET_SPEC,
+	})
+	if res == nil {
+		return nil
+	}
+	spec := bc.DeserializeSpec(res.Data)
+	res = nt.Send(addr, &nt.Package{
+		Option: GET_HEADERS,
+		Data:   fmt.Sprintf("%d%s%d", 0, SEPARATOR, 1),
+	})
+	if spec == nil || res == nil {
+		return nil
+	}
+	return bc.NewHeaderChain(bc.DeserializeHeader(res.Data), spec)
+}
+
 func handleClient() {
 	var (
 		message string
@@ -88,6 +172,70 @@ func handleClient() {
 				userPurse()
 			case "balance":
 				userBalance()
+			case "history":
+				userHistory(splited[1:])
+			}
+		case "/script":
+			if len(splited) < 2 {
+				fmt.Println("len(script) < 2")
+				continue
+			}
+			switch splited[1] {
+			case "new":
+				scriptNew(splited[1:])
+			case "tx":
+				scriptTX(splited[1:])
+			case "sign":
+				scriptWitness(splited[1:], true)
+			case "push":
+				scriptWitness(splited[1:], false)
+			case "send":
+				scriptSend(splited[1:])
+			}
+		case "/token":
+			if len(splited) < 2 {
+				fmt.Println("len(token) < 2")
+				continue
+			}
+			switch splited[1] {
+			case "issue":
+				tokenIssue(splited[1:])
+			case "mint":
+				tokenMint(splited[1:])
+			case "send":
+				tokenSend(splited[1:])
+			case "balance":
+				tokenBalance(splited[1:])
+			}
+		case "/htlc":
+			if len(splited) < 2 {
+				fmt.Println("len(htlc) < 2")
+				continue
+			}
+			switch splited[1] {
+			case "new":
+				htlcNew(splited[1:])
+			case "lock":
+				htlcLock(splited[1:])
+			case "claim":
+				htlcClaim(splited[1:])
+			case "refund":
+				htlcRefund(splited[1:])
+			}
+		case "/multisig":
+			if len(splited) < 2 {
+				fmt.Println("len(multisig) < 2")
+				continue
+			}
+			switch splited[1] {
+			case "new":
+				multisigNew(splited[1:])
+			case "tx":
+				multisigTX(splited[1:])
+			case "sign":
+				multisigSign(splited[1:])
+			case "send":
+				multisigSend(splited[1:])
 			}
 		case "/chain":
 			if len(splited) < 2 {
@@ -99,8 +247,24 @@ func handleClient() {
 				chainPrint()
 			case "tx":
 				chainTX(splited[1:])
+			case "txmemo":
+				chainTXMemo(splited[1:], false)
+			case "txenc":
+				chainTXMemo(splited[1:], true)
+			case "txlock":
+				chainTXLock(splited[1:])
+			case "paymany":
+				chainPayMany(splited[1:])
+			case "fee":
+				chainFee()
 			case "balance":
 				chainBalance(splited[1:])
+			case "history":
+				chainHistory(splited[1:])
+			case "gettx":
+				chainGetTX(splited[1:])
+			case "prove":
+				chainProve(splited[1:])
 			}
 		default:
 			fmt.Println("undefined command\n")
@@ -123,25 +287,36 @@ func userPurse() {
 }
 
 func userBalance() {
-	printBalance(User.Address())
+	printBalance(User.Address(), "coins")
+}
+
+func userHistory(splited []string) {
+	page := "0"
+	if len(splited) == 2 {
+		page = splited[1]
+	}
+	printHistory(User.Address(), page)
 }
 
 func chainPrint() {
-	for i := 0; ; i++ {
+	for i := 0; ; {
 		res := nt.Send(Addresses[0], &nt.Package{
-			Option: GET_BLOCK,
-			Data:   fmt.Sprintf("%d", i),
+			Option: GET_BLOCKS,
+			Data:   fmt.Sprintf("%d%s%d", i, SEPARATOR, BLOCKS_LIMIT),
 		})
 		if res == nil || res.Data == "" {
 			break
 		}
-		fmt.Printf("[%d] => %s\n", i+1, res.Data)
+		for _, sblock := range strings.Split(res.Data, SEPARATOR) {
+			fmt.Printf("[%d] => %s\n", i+1, bc.ToJSON(bc.DeserializeBlock(sblock)))
+			i++
+		}
 	}
 	fmt.Println()
 }
 
 func chainTX(splited []string) {
-	if len(splited) != 3 {
+	if len(splited) != 3 && len(splited) != 4 {
 		fmt.Println("len(splited) != 3 \n")
 		return
 	}
@@ -149,30 +324,657 @@ func chainTX(splited []string) {
 	if err != nil {
 		fmt.Println("strconv error \n")
 	}
-	for _, addr := range Addresses {
-		res := nt.Send(addr, &nt.Package{
-			Option: GET_LHASH,
+	var fee uint64
+	if len(splited) == 4 {
+		fee, err = strconv.ParseUint(splited[3], 10, 64)
+		if err != nil {
+			fmt.Println("strconv error \n")
+			return
+		}
+	}
+	sendTX([]bc.Output{{
+		Receiver: splited[1],
+		Value:    uint64(num),
+	}}, fee, nil)
+}
+
+// Перевод с текстом, например номером счета для сверки платежей.
+// Текст - все слова после комиссии. С encrypt текст шифруется ключом
+// получателя и читается только им.
+func chainTXMemo(splited []string, encrypt bool) {
+	if len(splited) < 5 {
+		fmt.Println("len(splited) < 5 \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[2:4])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	memo := []byte(strings.Join(splited[4:], " "))
+	limit := bc.MEMO_LIMIT
+	if encrypt {
+		pub := bc.ParsePublic(splited[1])
+		if pub == nil {
+			fmt.Println("receiver is not a public key \n")
+			return
+		}
+		var err error
+		memo, err = bc.EncryptMemo(pub, memo)
+		if err != nil {
+			fmt.Println(err, "\n")
+			return
+		}
+		limit += int(bc.MemoOverhead(Spec.KeySize))
+	}
+	if len(memo) > limit {
+		fmt.Println("memo is too large \n")
+		return
+	}
+	sendTX([]bc.Output{{
+		Receiver: splited[1],
+		Value:    value,
+	}}, fee, func(params *bc.TxParams) {
+		params.Data = memo
+		params.Encrypted = encrypt
+	})
+}
+
+// Перевод, который узлы примут в блок не раньше заданной высоты
+// или времени в формате RFC3339.
+func chainTXLock(splited []string) {
+	if len(splited) != 4 && len(splited) != 5 {
+		fmt.Println("len(splited) != 4 \n")
+		return
+	}
+	value, err := strconv.ParseUint(splited[2], 10, 64)
+	if err != nil {
+		fmt.Println("strconv error \n")
+		return
+	}
+	lockTime, ok := parseLockTime(splited[3])
+	if !ok {
+		fmt.Println("lock time error \n")
+		return
+	}
+	var fee uint64
+	if len(splited) == 5 {
+		fee, err = strconv.ParseUint(splited[4], 10, 64)
+		if err != nil {
+			fmt.Println("strconv error \n")
+			return
+		}
+	}
+	sendTX([]bc.Output{{
+		Receiver: splited[1],
+		Value:    value,
+	}}, fee, func(params *bc.TxParams) {
+		params.LockTime = lockTime
+	})
+}
+
+func parseLockTime(str string) (uint64, bool) {
+	if height, err := strconv.ParseUint(str, 10, 64); err == nil {
+		return height, height < bc.LOCKTIME_THRESHOLD
+	}
+	ltime, err := time.Parse(time.RFC3339, str)
+	if err != nil || ltime.Unix() < bc.LOCKTIME_THRESHOLD {
+		return 0, false
+	}
+	return uint64(ltime.Unix()), true
+}
+
+// Платеж нескольким получателям одной транзакцией. Файл CSV содержит
+// строки вида "адрес,сумма".
+func chainPayMany(splited []string) {
+	if len(splited) != 2 && len(splited) != 3 {
+		fmt.Println("len(splited) != 2 \n")
+		return
+	}
+	records, err := csv.NewReader(strings.NewReader(readFile(splited[1]))).ReadAll()
+	if err != nil || len(records) == 0 {
+		fmt.Println("csv error \n")
+		return
+	}
+	var outputs []bc.Output
+	for _, record := range records {
+		if len(record) != 2 {
+			fmt.Println("csv error \n")
+			return
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil {
+			fmt.Println("strconv error \n")
+			return
+		}
+		outputs = append(outputs, bc.Output{
+			Receiver: strings.TrimSpace(record[0]),
+			Value:    value,
 		})
-		if res == nil {
+	}
+	var fee uint64
+	if len(splited) == 3 {
+		fee, err = strconv.ParseUint(splited[2], 10, 64)
+		if err != nil {
+			fmt.Println("strconv error \n")
+			return
+		}
+	}
+	sendTX(outputs, fee, nil)
+}
+
+// Перевод с адреса пользователя. setup дополняет параметры
+// транзакции: блокировку, текст, токен.
+func sendTX(outputs []bc.Output, fee uint64, setup func(params *bc.TxParams)) {
+	for _, addr := range Addresses {
+		params := txParams(addr, User.Address())
+		if params == nil {
 			continue
 		}
-		tx := bc.NewTransaction(User, bc.Base64Decode(res.Data), splited[1], uint64(num))
+		if setup != nil {
+			setup(params)
+		}
+		tx := bc.NewTransaction(User, params, outputs, fee)
 		if tx == nil {
 			fmt.Println("tx is null \n")
 			break
 		}
-		res = nt.Send(addr, &nt.Package{
-			Option: ADD_TRNSX,
-			Data:   bc.SerializeTX(tx),
-		})
-		if res == nil {
+		pushTX(addr, tx)
+	}
+	fmt.Println()
+}
+
+func pushTX(addr string, tx *bc.Transaction) {
+	res := nt.Send(addr, &nt.Package{
+		Option: ADD_TRNSX,
+		Data:   bc.SerializeTX(tx),
+	})
+	if res == nil {
+		return
+	}
+	if res.Data == "ok" {
+		fmt.Printf("ok: (%s)\n", addr)
+	} else {
+		fmt.Printf("fail: (%s)\n", addr)
+	}
+}
+
+// Счет с несколькими ключами: политика хранится в файле, транзакция
+// передается между подписантами файлом и отправляется, когда собрано
+// достаточно подписей.
+func multisigNew(splited []string) {
+	if len(splited) < 3 {
+		fmt.Println("len(splited) < 3 \n")
+		return
+	}
+	threshold, err := strconv.ParseUint(splited[2], 10, 64)
+	if err != nil {
+		fmt.Println("strconv error \n")
+		return
+	}
+	policy := bc.NewPolicy(threshold, splited[3:])
+	if policy == nil {
+		fmt.Println("policy is not valid \n")
+		return
+	}
+	if writeFile(splited[1], bc.ToJSON(policy)) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Println("Address:", policy.Address(), "\n")
+}
+
+func multisigTX(splited []string) {
+	if len(splited) != 5 && len(splited) != 6 {
+		fmt.Println("len(splited) != 5 \n")
+		return
+	}
+	var policy *bc.Policy
+	if json.Unmarshal([]byte(readFile(splited[1])), &policy) != nil || !policy.IsValid() {
+		fmt.Println("policy is not valid \n")
+		return
+	}
+	value, err := strconv.ParseUint(splited[3], 10, 64)
+	if err != nil {
+		fmt.Println("strconv error \n")
+		return
+	}
+	var fee uint64
+	if len(splited) == 6 {
+		fee, err = strconv.ParseUint(splited[5], 10, 64)
+		if err != nil {
+			fmt.Println("strconv error \n")
+			return
+		}
+	}
+	params := txParams(Addresses[0], policy.Address())
+	if params == nil {
+		fmt.Println("params is null \n")
+		return
+	}
+	tx := bc.NewMultisigTransaction(policy, params, []bc.Output{{
+		Receiver: splited[2],
+		Value:    value,
+	}}, fee)
+	tx.SignMultisig(User)
+	writeMultisigTX(splited[4], tx)
+}
+
+func multisigSign(splited []string) {
+	if len(splited) != 2 {
+		fmt.Println("len(splited) != 2 \n")
+		return
+	}
+	tx := bc.DeserializeTX(readFile(splited[1]))
+	if tx == nil {
+		fmt.Println("tx is null \n")
+		return
+	}
+	if err := tx.SignMultisig(User); err != nil {
+		fmt.Println(err, "\n")
+		return
+	}
+	writeMultisigTX(splited[1], tx)
+}
+
+func multisigSend(splited []string) {
+	if len(splited) != 2 {
+		fmt.Println("len(splited) != 2 \n")
+		return
+	}
+	tx := bc.DeserializeTX(readFile(splited[1]))
+	if tx == nil || tx.Policy == nil {
+		fmt.Println("tx is null \n")
+		return
+	}
+	if tx.Signed() < tx.Policy.Threshold {
+		fmt.Printf("fail: signed %d of %d\n\n", tx.Signed(), tx.Policy.Threshold)
+		return
+	}
+	for _, addr := range Addresses {
+		pushTX(addr, tx)
+	}
+	fmt.Println()
+}
+
+// Счет со скриптом: текст скрипта хранится в файле, транзакция
+// передается файлом, пока в Witness не будут добавлены все данные.
+func scriptNew(splited []string) {
+	if len(splited) < 3 {
+		fmt.Println("len(splited) < 3 \n")
+		return
+	}
+	text := strings.Join(splited[2:], " ")
+	script, err := bc.ParseScript(text)
+	if err != nil {
+		fmt.Println(err, "\n")
+		return
+	}
+	if writeFile(splited[1], text) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Println("Address:", bc.ScriptAddress(script), "\n")
+}
+
+func scriptTX(splited []string) {
+	if len(splited) < 5 || len(splited) > 7 {
+		fmt.Println("len(splited) != 5 \n")
+		return
+	}
+	script, err := bc.ParseScript(readFile(splited[1]))
+	if err != nil {
+		fmt.Println(err, "\n")
+		return
+	}
+	args := []string{splited[3]}
+	if len(splited) > 5 {
+		args = append(args, splited[5])
+	}
+	value, fee, ok := parseValueFee(args)
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	params := txParams(Addresses[0], bc.ScriptAddress(script))
+	if params == nil {
+		fmt.Println("params is null \n")
+		return
+	}
+	if len(splited) == 7 {
+		if params.LockTime, ok = parseLockTime(splited[6]); !ok {
+			fmt.Println("lock time error \n")
+			return
+		}
+	}
+	tx := bc.NewScriptTransaction(script, params, []bc.Output{{
+		Receiver: splited[2],
+		Value:    value,
+	}}, fee)
+	writeScriptTX(splited[4], tx)
+}
+
+// Добавляет в Witness подпись пользователя или данные в hex.
+func scriptWitness(splited []string, sign bool) {
+	if (sign && len(splited) != 2) || (!sign && len(splited) != 3) {
+		fmt.Println("len(splited) is not valid \n")
+		return
+	}
+	tx := bc.DeserializeTX(readFile(splited[1]))
+	if tx == nil || len(tx.Script) == 0 {
+		fmt.Println("tx is null \n")
+		return
+	}
+	if sign {
+		tx.Witness = append(tx.Witness, bc.Sign(User.Private(), tx.CurrHash))
+	} else {
+		item, err := hex.DecodeString(strings.TrimPrefix(splited[2], "0x"))
+		if err != nil {
+			fmt.Println("hex error \n")
+			return
+		}
+		tx.Witness = append(tx.Witness, item)
+	}
+	writeScriptTX(splited[1], tx)
+}
+
+func scriptSend(splited []string) {
+	if len(splited) != 2 {
+		fmt.Println("len(splited) != 2 \n")
+		return
+	}
+	tx := bc.DeserializeTX(readFile(splited[1]))
+	if tx == nil || len(tx.Script) == 0 {
+		fmt.Println("tx is null \n")
+		return
+	}
+	if err := tx.RunScript(); err != nil {
+		fmt.Println("fail:", err, "\n")
+		return
+	}
+	for _, addr := range Addresses {
+		pushTX(addr, tx)
+	}
+	fmt.Println()
+}
+
+func writeScriptTX(filename string, tx *bc.Transaction) {
+	if writeFile(filename, bc.SerializeTX(tx)) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Printf("Witness: %d items (%s)\n\n", len(tx.Witness), filename)
+}
+
+// Выпуск токена: весь объем supply зачисляется пользователю, описание
+// токена сохраняется в файл для довыпуска.
+func tokenIssue(splited []string) {
+	if len(splited) != 5 && len(splited) != 6 {
+		fmt.Println("len(splited) != 5 \n")
+		return
+	}
+	var mintable bool
+	switch splited[3] {
+	case "fixed":
+	case "mintable":
+		mintable = true
+	default:
+		fmt.Println("supply is not fixed or mintable \n")
+		return
+	}
+	supply, fee, ok := parseValueFee(splited[4:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	var token *bc.Token
+	sendTX([]bc.Output{{
+		Receiver: User.Address(),
+		Value:    supply,
+	}}, fee, func(params *bc.TxParams) {
+		if token == nil {
+			token = &bc.Token{
+				Issuer:   User.Address(),
+				Name:     splited[2],
+				Mintable: mintable,
+				Nonce:    params.Nonce,
+			}
+		}
+		params.Asset = token.ID()
+		params.Token = token
+	})
+	if token == nil {
+		return
+	}
+	if writeFile(splited[1], bc.ToJSON(token)) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Println("Asset:", token.ID(), "\n")
+}
+
+func tokenMint(splited []string) {
+	if len(splited) != 3 && len(splited) != 4 {
+		fmt.Println("len(splited) != 3 \n")
+		return
+	}
+	var token *bc.Token
+	if json.Unmarshal([]byte(readFile(splited[1])), &token) != nil || !token.IsValid() {
+		fmt.Println("token is not valid \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[2:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	sendTX([]bc.Output{{
+		Receiver: User.Address(),
+		Value:    value,
+	}}, fee, func(params *bc.TxParams) {
+		params.Asset = token.ID()
+		params.Token = token
+	})
+}
+
+func tokenSend(splited []string) {
+	if len(splited) != 4 && len(splited) != 5 {
+		fmt.Println("len(splited) != 4 \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[3:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	sendTX([]bc.Output{{
+		Receiver: splited[2],
+		Value:    value,
+	}}, fee, func(params *bc.TxParams) {
+		params.Asset = splited[1]
+	})
+}
+
+func tokenBalance(splited []string) {
+	if len(splited) != 2 && len(splited) != 3 {
+		fmt.Println("len(splited) != 2 \n")
+		return
+	}
+	address := User.Address()
+	if len(splited) == 3 {
+		address = splited[2]
+	}
+	printBalance(bc.TokenAccount(splited[1], address), "tokens")
+}
+
+// Условный перевод: контракт хранится в файле у обеих сторон. Без
+// хеша создается новый секрет, который получает только создатель.
+func htlcNew(splited []string) {
+	if len(splited) != 4 && len(splited) != 5 {
+		fmt.Println("len(splited) != 4 \n")
+		return
+	}
+	timeout, err := strconv.ParseUint(splited[3], 10, 64)
+	if err != nil {
+		fmt.Println("strconv error \n")
+		return
+	}
+	var hashLock []byte
+	if len(splited) == 5 {
+		hashLock, err = hex.DecodeString(splited[4])
+		if err != nil {
+			fmt.Println("hex error \n")
+			return
+		}
+	} else {
+		preimage := make([]byte, 32)
+		if _, err := rand.Read(preimage); err != nil {
+			fmt.Println("rand error \n")
+			return
+		}
+		hashLock = bc.HashSum(preimage)
+		fmt.Println("Preimage:", hex.EncodeToString(preimage))
+	}
+	contract := bc.NewContract(User.Address(), splited[2], hashLock, timeout)
+	if contract == nil {
+		fmt.Println("contract is not valid \n")
+		return
+	}
+	if writeFile(splited[1], bc.ToJSON(contract)) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Println("Hash:", hex.EncodeToString(hashLock))
+	fmt.Println("Address:", contract.Address(), "\n")
+}
+
+func htlcLock(splited []string) {
+	if len(splited) != 3 && len(splited) != 4 {
+		fmt.Println("len(splited) != 3 \n")
+		return
+	}
+	contract := loadContract(splited[1])
+	if contract == nil {
+		fmt.Println("contract is not valid \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[2:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	sendTX([]bc.Output{{
+		Receiver: contract.Address(),
+		Value:    value,
+	}}, fee, nil)
+}
+
+func htlcClaim(splited []string) {
+	if len(splited) != 4 && len(splited) != 5 {
+		fmt.Println("len(splited) != 4 \n")
+		return
+	}
+	contract := loadContract(splited[1])
+	if contract == nil {
+		fmt.Println("contract is not valid \n")
+		return
+	}
+	preimage, err := hex.DecodeString(splited[2])
+	if err != nil {
+		fmt.Println("hex error \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[3:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	sendContractTX(contract, preimage, value, fee)
+}
+
+func htlcRefund(splited []string) {
+	if len(splited) != 3 && len(splited) != 4 {
+		fmt.Println("len(splited) != 3 \n")
+		return
+	}
+	contract := loadContract(splited[1])
+	if contract == nil {
+		fmt.Println("contract is not valid \n")
+		return
+	}
+	value, fee, ok := parseValueFee(splited[2:])
+	if !ok {
+		fmt.Println("strconv error \n")
+		return
+	}
+	sendContractTX(contract, nil, value, fee)
+}
+
+// Перевод value с адреса контракта пользователю: с прообразом - получение,
+// без него - возврат.
+func sendContractTX(contract *bc.Contract, preimage []byte, value, fee uint64) {
+	outputs := []bc.Output{{
+		Receiver: User.Address(),
+		Value:    value,
+	}}
+	for _, addr := range Addresses {
+		params := txParams(addr, contract.Address())
+		if params == nil {
 			continue
 		}
-		if res.Data == "ok" {
-			fmt.Printf("ok: (%s)\n", addr)
+		var tx *bc.Transaction
+		if preimage != nil {
+			tx = bc.NewClaimTransaction(User, contract, preimage, params, outputs, fee)
 		} else {
-			fmt.Printf("fail: (%s)\n", addr)
+			tx = bc.NewRefundTransaction(User, contract, params, outputs, fee)
 		}
+		pushTX(addr, tx)
+	}
+	fmt.Println()
+}
+
+func loadContract(filename string) *bc.Contract {
+	var contract *bc.Contract
+	if json.Unmarshal([]byte(readFile(filename)), &contract) != nil || !contract.IsValid() {
+		return nil
+	}
+	return contract
+}
+
+// Сумма и необязательная комиссия из аргументов команды.
+func parseValueFee(args []string) (uint64, uint64, bool) {
+	value, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(args) == 1 {
+		return value, 0, true
+	}
+	fee, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return value, fee, true
+}
+
+func writeMultisigTX(filename string, tx *bc.Transaction) {
+	if writeFile(filename, bc.SerializeTX(tx)) != nil {
+		fmt.Println("write error \n")
+		return
+	}
+	fmt.Printf("Signed: %d of %d (%s)\n\n", tx.Signed(), tx.Policy.Threshold, filename)
+}
+
+// Комиссия, которую узлы считают достаточной для следующего блока.
+func chainFee() {
+	for _, addr := range Addresses {
+		res := nt.Send(addr, &nt.Package{
+			Option: GET_FEE,
+		})
+		if res == nil {
+			continue
+		}
+		fmt.Printf("Fee: %s (%s)\n", res.Data, addr)
 	}
 	fmt.Println()
 }
@@ -182,10 +984,14 @@ func chainBalance(splited []string) {
 		fmt.Println("len(splited) != 2\n")
 		return
 	}
-	printBalance(splited[1])
+	printBalance(splited[1], "coins")
 }
 
-func printBalance(address string) {
+func printBalance(address, unit string) {
+	if Light {
+		lightBalance(address, unit)
+		return
+	}
 	for _, addr := range Addresses {
 		res := nt.Send(addr, &nt.Package{
 			Option: GET_BALANCE,
@@ -194,7 +1000,378 @@ func printBalance(address string) {
 		if res == nil {
 			continue
 		}
-		fmt.Printf("Balnce (%s): %s coins\n", addr, res.Data)
+		fmt.Printf("Balnce (%s): %s %s\n", addr, res.Data, unit)
+	}
+	fmt.Println()
+}
+
+func chainHistory(splited []string) {
+	if len(splited) != 2 && len(splited) != 3 {
+		fmt.Println("len(splited) != 2\n")
+		return
+	}
+	page := "0"
+	if len(splited) == 3 {
+		page = splited[2]
+	}
+	printHistory(splited[1], page)
+}
+
+func chainGetTX(splited []string) {
+	if len(splited) != 2 {
+		fmt.Println("len(splited) != 2\n")
+		return
+	}
+	res := nt.Send(Addresses[0], &nt.Package{
+		Option: GET_TRNSX,
+		Data:   splited[1],
+	})
+	if res == nil || res.Data == "" {
+		fmt.Println("tx not found\n")
+		return
+	}
+	if Light {
+		syncHeaders()
+	}
+	for _, record := range bc.DeserializeRecords(res.Data) {
+		if Light && !recordIsValid(Addresses[0], record) {
+			fmt.Printf("fail: record [%d] is not valid\n", record.Height)
+			continue
+		}
+		fmt.Printf("[%d] => %s\n", record.Height, bc.ToJSON(record.Transaction))
+	}
+	fmt.Println()
+}
+
+func chainProve(splited []string) {
+	if len(splited) != 2 {
+		fmt.Println("len(splited) != 2\n")
+		return
+	}
+	res := nt.Send(Addresses[0], &nt.Package{
+		Option: GET_TRNSX,
+		Data:   splited[1],
+	})
+	if res == nil || res.Data == "" {
+		fmt.Println("tx not found\n")
+		return
+	}
+	records := bc.DeserializeRecords(res.Data)
+	if len(records) != 1 || bc.Base64Encode(records[0].Transaction.CurrHash) != splited[1] {
+		fmt.Println("tx not found\n")
+		return
+	}
+	header := getHeader(Addresses[0], records[0].Height)
+	if header == nil {
+		fmt.Println("header is null\n")
+		return
+	}
+	res = nt.Send(Addresses[0], &nt.Package{
+		Option: GET_PROOF,
+		Data:   bc.Base64Encode(header.CurrHash) + SEPARATOR + splited[1],
+	})
+	if res == nil {
+		fmt.Println("proof is null\n")
+		return
+	}
+	proof := bc.DeserializeProof(res.Data)
+	if proof == nil {
+		fmt.Println("proof is null\n")
+		return
+	}
+	if !header.VerifyTransaction(&records[0].Transaction, proof) {
+		fmt.Printf("fail: tx is not in block [%d]\n\n", records[0].Height)
+		return
+	}
+	fmt.Printf("ok: tx is in block [%d] (%s)\n\n", records[0].Height, bc.Base64Encode(header.CurrHash))
+}
+
+func printHistory(address, page string) {
+	res := nt.Send(Addresses[0], &nt.Package{
+		Option: GET_HISTORY,
+		Data:   address + SEPARATOR + page,
+	})
+	if res == nil {
+		fmt.Println("history is null\n")
+		return
+	}
+	if Light {
+		syncHeaders()
+	}
+	for _, record := range bc.DeserializeRecords(res.Data) {
+		if Light && !recordIsValid(Addresses[0], record) {
+			fmt.Printf("fail: record [%d] is not valid\n", record.Height)
+			continue
+		}
+		tx := record.Transaction
+		if tx.Sender == address {
+			var receivers []string
+			for _, output := range tx.Outputs {
+				receivers = append(receivers, output.Receiver)
+			}
+			fmt.Printf("[%d] -%d coins => %s (%s)\n", record.Height, tx.Debit(address), strings.Join(receivers, ", "), bc.Base64Encode(tx.CurrHash))
+		} else {
+			var received uint64
+			if tx.Asset == "" {
+				received = tx.Received(address)
+			}
+			fmt.Printf("[%d] +%d coins <= %s (%s)\n", record.Height, received, tx.Sender, bc.Base64Encode(tx.CurrHash))
+		}
+		printTokens(&tx, address)
+		printMemo(&tx)
+		if tx.LockTime != 0 {
+			fmt.Println("\tlock time:", lockTimeString(tx.LockTime))
+		}
+		if len(tx.Preimage) != 0 {
+			fmt.Println("\tpreimage:", hex.EncodeToString(tx.Preimage))
+		}
+	}
+	fmt.Println()
+}
+
+func printTokens(tx *bc.Transaction, address string) {
+	amount, _ := tx.Amount()
+	switch {
+	case tx.Asset == "":
+		return
+	case tx.Token != nil && tx.Sender == address:
+		fmt.Printf("\ttokens: issued %d %s (%s)\n", amount, tx.Token.Name, tx.Asset)
+	case tx.Sender == address:
+		fmt.Printf("\ttokens: -%d (%s)\n", amount, tx.Asset)
+	default:
+		fmt.Printf("\ttokens: +%d (%s)\n", tx.Received(address), tx.Asset)
+	}
+}
+
+// Зашифрованный текст показывается, если он адресован пользователю.
+func printMemo(tx *bc.Transaction) {
+	switch {
+	case len(tx.Data) == 0:
+		return
+	case !tx.Encrypted:
+		fmt.Printf("\tmemo: %q\n", tx.Data)
+		return
+	}
+	memo, err := bc.DecryptMemo(User.Private(), tx.Data)
+	if err != nil {
+		fmt.Println("\tmemo: (encrypted)")
+		return
+	}
+	fmt.Printf("\tmemo (decrypted): %q\n", memo)
+}
+
+func lockTimeString(lockTime uint64) string {
+	if lockTime < bc.LOCKTIME_THRESHOLD {
+		return fmt.Sprintf("height %d", lockTime)
+	}
+	return time.Unix(int64(lockTime), 0).Format(time.RFC3339)
+}
+
+// Параметры транзакции со счета sender: вершина цепочки и следующий
+// номер транзакции по данным узла.
+func txParams(addr, sender string) *bc.TxParams {
+	res := nt.Send(addr, &nt.Package{
+		Option: GET_LHASH,
+	})
+	if res == nil {
+		return nil
+	}
+	lasthash := bc.Base64Decode(res.Data)
+	res = nt.Send(addr, &nt.Package{
+		Option: GET_NONCE,
+		Data:   sender,
+	})
+	if res == nil {
+		return nil
+	}
+	nonce, err := strconv.ParseUint(res.Data, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &bc.TxParams{
+		Spec:     Spec,
+		ChainID:  Headers.ID(),
+		LastHash: lasthash,
+		Nonce:    nonce,
+	}
+}
+
+// В легком режиме заголовок берется из проверенной цепочки заголовков,
+// иначе запрашивается у узла.
+func getHeader(addr string, height uint64) *bc.BlockHeader {
+	if Light {
+		syncHeaders()
+		return Headers.Header(height)
+	}
+	res := nt.Send(addr, &nt.Package{
+		Option: GET_HEADERS,
+		Data:   fmt.Sprintf("%d%s%d", height-1, SEPARATOR, 1),
+	})
+	if res == nil {
+		return nil
+	}
+	return bc.DeserializeHeader(res.Data)
+}
+
+// Загружает заголовки от всех узлов и оставляет ветку с наибольшей работой.
+// Генезис и спецификация выбираются при запуске в loadGenesis.
+func syncHeaders() {
+	for _, addr := range Addresses {
+		var locator []string
+		for _, hash := range Headers.Locator() {
+			locator = append(locator, bc.Base64Encode(hash))
+		}
+		res := nt.Send(addr, &nt.Package{
+			Option: GET_FORK,
+			Data:   strings.Join(locator, SEPARATOR),
+		})
+		if res == nil || res.Data == "" {
+			continue
+		}
+		fork, err := strconv.ParseUint(res.Data, 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := Headers.Append(fetchHeaders(addr, fork)); err != nil {
+			fmt.Printf("fail: headers (%s): %s\n", addr, err)
+		}
+	}
+}
+
+// Заголовки узла addr от высоты fork пачками по BLOCKS_LIMIT. Загрузка
+// останавливается на неполной пачке, на заголовке, который не
+// разбирается или не ссылается на предыдущий, и на лишних заголовках
+// сверх запрошенных.
+func fetchHeaders(addr string, fork uint64) []*bc.BlockHeader {
+	var headers []*bc.BlockHeader
+	for i := fork; ; {
+		res := nt.Send(addr, &nt.Package{
+			Option: GET_HEADERS,
+			Data:   fmt.Sprintf("%d%s%d", i, SEPARATOR, BLOCKS_LIMIT),
+		})
+		if res == nil || res.Data == "" {
+			return headers
+		}
+		splited := strings.Split(res.Data, SEPARATOR)
+		if len(splited) > BLOCKS_LIMIT {
+			splited = splited[:BLOCKS_LIMIT]
+		}
+		for _, sheader := range splited {
+			header := bc.DeserializeHeader(sheader)
+			if header == nil {
+				return headers
+			}
+			if len(headers) != 0 && !bytes.Equal(header.PrevHash, headers[len(headers)-1].CurrHash) {
+				return headers
+			}
+			headers = append(headers, header)
+			i++
+		}
+		if len(splited) < BLOCKS_LIMIT {
+			return headers
+		}
+	}
+}
+
+// Доказательство подтверждает баланс только на своей высоте, и узел
+// может прислать старое. Поэтому доказательства старше последнего
+// известного изменения счета - по проверенной истории и доказательствам
+// других узлов - отвергаются, а нулевой баланс без доказательства
+// показывается как непроверенный.
+func lightBalance(account, unit string) {
+	syncHeaders()
+	var (
+		proofs = make(map[string]*bc.BalanceProof)
+		newest = lastChange(account)
+	)
+	for _, addr := range Addresses {
+		res := nt.Send(addr, &nt.Package{
+			Option: GET_BPROOF,
+			Data:   account,
+		})
+		if res == nil {
+			continue
+		}
+		if res.Data == "" {
+			proofs[addr] = nil
+			continue
+		}
+		proof := bc.DeserializeBalanceProof(res.Data)
+		if proof == nil || proof.Address != account || !Headers.VerifyBalance(proof) {
+			fmt.Printf("fail: proof is not valid (%s)\n", addr)
+			continue
+		}
+		proofs[addr] = proof
+		if proof.Height > newest {
+			newest = proof.Height
+		}
+	}
+	for _, addr := range Addresses {
+		proof, ok := proofs[addr]
+		switch {
+		case !ok:
+			continue
+		case proof == nil && newest == 0:
+			fmt.Printf("Balnce (%s): 0 %s (unverified)\n", addr, unit)
+		case proof == nil:
+			fmt.Printf("fail: no proof for block %d (%s)\n", newest, addr)
+		case proof.Height < newest:
+			fmt.Printf("fail: proof is older than block %d (%s)\n", newest, addr)
+		default:
+			fmt.Printf("Balnce (%s): %d %s [%d]\n", addr, proof.Balance, unit, proof.Height)
+		}
 	}
 	fmt.Println()
 }
+
+// Высота последнего блока, изменившего счет, по проверенной первой
+// странице истории владельца у каждого узла.
+func lastChange(account string) uint64 {
+	address := account
+	if bc.IsToken(account) {
+		address = account[strings.LastIndex(account, ":")+1:]
+	}
+	var height uint64
+	for _, addr := range Addresses {
+		res := nt.Send(addr, &nt.Package{
+			Option: GET_HISTORY,
+			Data:   address + SEPARATOR + "0",
+		})
+		if res == nil {
+			continue
+		}
+		for _, record := range bc.DeserializeRecords(res.Data) {
+			if record.Height <= height || !changes(&record.Transaction, account) {
+				continue
+			}
+			if recordIsValid(addr, record) {
+				height = record.Height
+			}
+		}
+	}
+	return height
+}
+
+func changes(tx *bc.Transaction, account string) bool {
+	for _, acc := range tx.Accounts() {
+		if acc == account {
+			return true
+		}
+	}
+	return false
+}
+
+func recordIsValid(addr string, record bc.TxRecord) bool {
+	header := Headers.Header(record.Height)
+	if header == nil {
+		return false
+	}
+	res := nt.Send(addr, &nt.Package{
+		Option: GET_PROOF,
+		Data:   bc.Base64Encode(header.CurrHash) + SEPARATOR + bc.Base64Encode(record.Transaction.CurrHash),
+	})
+	if res == nil {
+		return false
+	}
+	return Headers.VerifyTransaction(&record.Transaction, record.Height, bc.DeserializeProof(res.Data))
+}
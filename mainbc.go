@@ -10,26 +10,24 @@ const (
 )
 
 func main() {
-	miner := bc.NewUser()
-	bc.NewChain(DBNAME, miner.Address())
-	chain := bc.LoadChain(DBNAME)
+	miner := bc.NewUser(bc.DefaultSpec("").KeySize)
+	bc.NewChain(DBNAME, bc.DefaultSpec(miner.Address()))
+	chain, err := bc.LoadChain(DBNAME)
+	if err != nil {
+		panic(err)
+	}
 	fmt.Println(chain)
 	for i := 0; i < 3; i++ {
 		fmt.Println(miner.Address())
 		block := bc.NewBlock(miner.Address(), chain.LastHash())
-		block.AddTransaction(chain, bc.NewTransaction(miner, chain.LastHash(), "aaa", 5))
-		block.AddTransaction(chain, bc.NewTransaction(miner, chain.LastHash(), "bbb", 2))
+		params := chain.TxParams(miner.Address())
+		block.AddTransaction(chain, bc.NewTransaction(miner, params, []bc.Output{{Receiver: "aaa", Value: 5}}, 1))
+		params.Nonce++
+		block.AddTransaction(chain, bc.NewTransaction(miner, params, []bc.Output{{Receiver: "bbb", Value: 2}}, 0))
 		block.Accept(chain, miner, make(chan bool))
 		chain.AddBlock(block)
 	}
-	var sblock string
-	rows, err := chain.DB.Query("SELECT Block FROM BlockChain")
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	for rows.Next() {
-		rows.Scan(&sblock)
-		fmt.Println(sblock)
+	for i := uint64(1); i <= chain.Size(); i++ {
+		fmt.Println(bc.ToJSON(chain.Block(i)))
 	}
 }
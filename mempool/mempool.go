@@ -0,0 +1,306 @@
+package mempool
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	bc "tchain/blockchain"
+)
+
+var (
+	ErrPoolFull  = errors.New("pool is full")
+	ErrKnownTx   = errors.New("tx already in pool")
+	ErrInvalidTx = errors.New("tx is not valid")
+	ErrLargeData = errors.New("tx data is too large")
+)
+
+// Пул ожидающих транзакций. Хранит больше транзакций, чем помещается
+// в блок, перепроверяет их после каждой смены вершины и собирает
+// из них шаблоны блоков. Транзакции с LockTime ждут в пуле, пока
+// не станут действительными.
+type Pool struct {
+	mutex  sync.Mutex
+	txs    map[string]*entry
+	limit  int
+	expiry time.Duration
+	data   int
+	seq    uint64
+}
+
+type entry struct {
+	tx    bc.Transaction
+	added time.Time
+	seq   uint64
+}
+
+// Пул принимает транзакции с memo не длиннее data байт без учета
+// шифрования: узел может ограничить их сильнее, чем правила блока.
+func NewPool(limit int, expiry time.Duration, data int) *Pool {
+	return &Pool{
+		txs:    make(map[string]*entry),
+		limit:  limit,
+		expiry: expiry,
+		data:   data,
+	}
+}
+
+func (pool *Pool) Size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.txs)
+}
+
+// Принимает транзакцию, если ее можно применить к вершине chain
+// после уже ожидающих транзакций отправителя.
+func (pool *Pool) Add(chain *bc.BlockChain, tx *bc.Transaction) error {
+	if !tx.IsValid(chain) {
+		return ErrInvalidTx
+	}
+	if tx.MemoSize(chain.Spec) > uint64(pool.data) {
+		return ErrLargeData
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	hash := bc.Base64Encode(tx.CurrHash)
+	if _, ok := pool.txs[hash]; ok {
+		return ErrKnownTx
+	}
+	for _, e := range pool.txs {
+		if e.tx.Sender == tx.Sender && e.tx.Nonce == tx.Nonce {
+			return ErrKnownTx
+		}
+	}
+	// Проверка до вытеснения: иначе транзакция без средств освобождала
+	// бы место, вытесняя действительные.
+	state := newSenderState(chain, tx.Sender)
+	for _, e := range pool.sender(tx.Sender) {
+		if e.tx.Nonce >= state.nonce && !state.apply(&e.tx) {
+			break
+		}
+	}
+	if !state.apply(tx) {
+		return ErrInvalidTx
+	}
+	if len(pool.txs) >= pool.limit && !pool.evict(tx) {
+		return ErrPoolFull
+	}
+	pool.seq++
+	pool.txs[hash] = &entry{
+		tx:    *tx,
+		added: time.Now(),
+		seq:   pool.seq,
+	}
+	return nil
+}
+
+// Вызывается после смены вершины: удаляет вошедшие в цепочку,
+// устаревшие и ставшие неприменимыми транзакции. Срок хранения
+// заблокированной транзакции отсчитывается с момента, когда она
+// становится действительной.
+func (pool *Pool) Update(chain *bc.BlockChain) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	senders := make(map[string]bool)
+	for hash, e := range pool.txs {
+		if !e.tx.IsFinal(chain.Size()+1, time.Now()) {
+			e.added = time.Now()
+		}
+		if time.Since(e.added) > pool.expiry || !e.tx.IsValid(chain) {
+			delete(pool.txs, hash)
+			continue
+		}
+		senders[e.tx.Sender] = true
+	}
+	for sender := range senders {
+		pool.revalidate(chain, sender)
+	}
+}
+
+// Номер следующей транзакции адреса с учетом ожидающих в пуле.
+func (pool *Pool) NextNonce(chain *bc.BlockChain, address string) uint64 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	nonce := chain.Nonce(address, chain.Size())
+	for _, e := range pool.sender(address) {
+		if e.tx.Nonce != nonce {
+			break
+		}
+		nonce++
+	}
+	return nonce
+}
+
+// Число транзакций, которые можно включить в следующий блок: у каждого
+// отправителя - до первой заблокированной.
+func (pool *Pool) Ready(chain *bc.BlockChain) int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.ready(chain))
+}
+
+// Блок на вершине chain из ожидающих транзакций. Каждый раз берется
+// транзакция с наибольшей комиссией среди тех, чей номер следующий
+// у отправителя; при равной комиссии - поступившая раньше.
+func (pool *Pool) Template(chain *bc.BlockChain, miner string) *bc.Block {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	list := pool.ready(chain)
+	block := bc.NewBlock(miner, chain.LastHash())
+	for uint64(len(block.Transactions)) < chain.Spec.TxsLimit {
+		next := -1
+		for i, e := range list {
+			if e.tx.Nonce == block.NextNonce(chain, e.tx.Sender) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			break
+		}
+		block.AddTransaction(chain, &list[next].tx)
+		list = append(list[:next], list[next+1:]...)
+	}
+	return block
+}
+
+// Комиссия, достаточная для попадания в следующий блок: если ожидающих
+// транзакций больше, чем помещается в блок, нужно превысить комиссию
+// последней из поместившихся.
+func (pool *Pool) EstimateFee(chain *bc.BlockChain) uint64 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	list := pool.ready(chain)
+	if uint64(len(list)) < chain.Spec.TxsLimit {
+		return 0
+	}
+	return list[chain.Spec.TxsLimit-1].tx.Fee + 1
+}
+
+// Проверяет транзакции отправителя по порядку номеров от состояния
+// вершины. Транзакция с пропуском номера или без средств на каком-либо
+// из счетов удаляется вместе со всеми следующими за ней.
+func (pool *Pool) revalidate(chain *bc.BlockChain, sender string) {
+	var (
+		state = newSenderState(chain, sender)
+		valid = true
+	)
+	for _, e := range pool.sender(sender) {
+		if e.tx.Nonce < state.nonce {
+			delete(pool.txs, bc.Base64Encode(e.tx.CurrHash))
+			continue
+		}
+		if !valid || !state.apply(&e.tx) {
+			valid = false
+			delete(pool.txs, bc.Base64Encode(e.tx.CurrHash))
+		}
+	}
+}
+
+// Номер и балансы счетов отправителя по мере применения его транзакций
+// к вершине цепочки.
+type senderState struct {
+	chain    *bc.BlockChain
+	nonce    uint64
+	balances map[string]uint64
+}
+
+func newSenderState(chain *bc.BlockChain, sender string) *senderState {
+	return &senderState{
+		chain:    chain,
+		nonce:    chain.Nonce(sender, chain.Size()),
+		balances: make(map[string]uint64),
+	}
+}
+
+// Применяет tx, если ее номер следующий и средств на счетах хватает.
+func (state *senderState) apply(tx *bc.Transaction) bool {
+	if tx.Nonce != state.nonce {
+		return false
+	}
+	for _, account := range tx.Accounts() {
+		if _, ok := state.balances[account]; !ok {
+			state.balances[account] = state.chain.Balance(account, state.chain.Size())
+		}
+		if tx.Debit(account) > state.balances[account] {
+			return false
+		}
+	}
+	for _, account := range tx.Accounts() {
+		state.balances[account] -= tx.Debit(account)
+	}
+	state.nonce++
+	return true
+}
+
+// Освобождает место для транзакции tx, удаляя транзакцию с наименьшей
+// комиссией, если она меньше комиссии tx. Удаляются только последние
+// транзакции других отправителей, чтобы не оставлять пропусков в номерах.
+func (pool *Pool) evict(tx *bc.Transaction) bool {
+	last := make(map[string]uint64)
+	for _, e := range pool.txs {
+		if nonce, ok := last[e.tx.Sender]; !ok || e.tx.Nonce > nonce {
+			last[e.tx.Sender] = e.tx.Nonce
+		}
+	}
+	list := pool.sorted()
+	for i := len(list) - 1; i >= 0 && list[i].tx.Fee < tx.Fee; i-- {
+		if list[i].tx.Sender != tx.Sender && list[i].tx.Nonce == last[list[i].tx.Sender] {
+			delete(pool.txs, bc.Base64Encode(list[i].tx.CurrHash))
+			return true
+		}
+	}
+	return false
+}
+
+// Транзакции по убыванию комиссии, затем по времени поступления.
+func (pool *Pool) sorted() []*entry {
+	var list []*entry
+	for _, e := range pool.txs {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].tx.Fee != list[j].tx.Fee {
+			return list[i].tx.Fee > list[j].tx.Fee
+		}
+		return list[i].seq < list[j].seq
+	})
+	return list
+}
+
+// Транзакции в порядке sorted без заблокированных и следующих за ними.
+func (pool *Pool) ready(chain *bc.BlockChain) []*entry {
+	var (
+		locked = make(map[string]uint64)
+		list   []*entry
+		now    = time.Now()
+	)
+	for _, e := range pool.txs {
+		if e.tx.IsFinal(chain.Size()+1, now) {
+			continue
+		}
+		if nonce, ok := locked[e.tx.Sender]; !ok || e.tx.Nonce < nonce {
+			locked[e.tx.Sender] = e.tx.Nonce
+		}
+	}
+	for _, e := range pool.sorted() {
+		if nonce, ok := locked[e.tx.Sender]; !ok || e.tx.Nonce < nonce {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func (pool *Pool) sender(address string) []*entry {
+	var list []*entry
+	for _, e := range pool.txs {
+		if e.tx.Sender == address {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].tx.Nonce < list[j].tx.Nonce
+	})
+	return list
+}
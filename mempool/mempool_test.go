@@ -0,0 +1,137 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	bc "tchain/blockchain"
+)
+
+var (
+	testUsers    = []*bc.User{bc.NewUser(512), bc.NewUser(512), bc.NewUser(512)}
+	testReceiver = bc.NewUser(512).Address()
+)
+
+func testChain(t *testing.T) *bc.BlockChain {
+	t.Helper()
+	spec := bc.DefaultSpec("")
+	for _, user := range testUsers {
+		spec.Allocations = append(spec.Allocations, bc.Allocation{
+			Address: user.Address(),
+			Value:   100,
+		})
+	}
+	chain := bc.NewMemoryChain(spec)
+	if chain == nil {
+		t.Fatal("chain is null")
+	}
+	return chain
+}
+
+func testTX(chain *bc.BlockChain, user *bc.User, nonce, value, fee uint64) *bc.Transaction {
+	params := chain.TxParams(user.Address())
+	params.Nonce = nonce
+	return bc.NewTransaction(user, params, []bc.Output{{Receiver: testReceiver, Value: value}}, fee)
+}
+
+func TestPoolAdd(t *testing.T) {
+	var (
+		chain = testChain(t)
+		pool  = NewPool(10, time.Hour, 100)
+		user  = testUsers[0]
+	)
+	first := testTX(chain, user, 0, 1, 1)
+	tests := []struct {
+		name string
+		tx   *bc.Transaction
+		err  error
+	}{
+		{"first", first, nil},
+		{"same tx", first, ErrKnownTx},
+		{"same nonce", testTX(chain, user, 0, 2, 1), ErrKnownTx},
+		{"gap", testTX(chain, user, 2, 1, 1), ErrInvalidTx},
+		{"next", testTX(chain, user, 1, 1, 1), nil},
+		{"no funds", testTX(chain, user, 2, 95, 1), ErrInvalidTx},
+		{"funded", testTX(chain, user, 2, 90, 1), nil},
+	}
+	for _, test := range tests {
+		if err := pool.Add(chain, test.tx); err != test.err {
+			t.Errorf("%s: Add() = %v, want %v", test.name, err, test.err)
+		}
+	}
+	if size := pool.Size(); size != 3 {
+		t.Errorf("Size() = %d, want 3", size)
+	}
+	if nonce := pool.NextNonce(chain, user.Address()); nonce != 3 {
+		t.Errorf("NextNonce() = %d, want 3", nonce)
+	}
+}
+
+func TestPoolEvict(t *testing.T) {
+	var (
+		chain      = testChain(t)
+		pool       = NewPool(3, time.Hour, 100)
+		x, y, z    = testUsers[0], testUsers[1], testUsers[2]
+		low, queue = testTX(chain, x, 0, 1, 0), testTX(chain, x, 1, 1, 5)
+		mid        = testTX(chain, y, 0, 1, 2)
+	)
+	for _, tx := range []*bc.Transaction{low, queue, mid} {
+		if err := pool.Add(chain, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		tx   *bc.Transaction
+		err  error
+	}{
+		// Транзакция с наименьшей комиссией не последняя у отправителя,
+		// а следующие за ней дороже.
+		{"lower than evictable", testTX(chain, z, 0, 1, 1), ErrPoolFull},
+		{"no funds", testTX(chain, z, 0, 200, 10), ErrInvalidTx},
+		{"higher fee", testTX(chain, z, 0, 1, 3), nil},
+		{"equal fee", testTX(chain, y, 0, 2, 3), ErrPoolFull},
+	}
+	for _, test := range tests {
+		if err := pool.Add(chain, test.tx); err != test.err {
+			t.Errorf("%s: Add() = %v, want %v", test.name, err, test.err)
+		}
+	}
+	if size := pool.Size(); size != 3 {
+		t.Errorf("Size() = %d, want 3", size)
+	}
+	if err := pool.Add(chain, mid); err != ErrPoolFull {
+		t.Errorf("evicted tx: Add() = %v, want %v", err, ErrPoolFull)
+	}
+}
+
+func TestPoolTemplate(t *testing.T) {
+	var (
+		chain = testChain(t)
+		pool  = NewPool(10, time.Hour, 100)
+		x, y  = testUsers[0], testUsers[1]
+		txs   = []*bc.Transaction{
+			testTX(chain, x, 0, 1, 1),
+			testTX(chain, x, 1, 1, 9),
+			testTX(chain, y, 0, 1, 5),
+		}
+	)
+	for _, tx := range txs {
+		if err := pool.Add(chain, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := pool.Template(chain, testReceiver)
+	if len(block.Transactions) != 2 {
+		t.Fatalf("Template() has %d transactions, want 2", len(block.Transactions))
+	}
+	// Дорогая вторая транзакция x ждет первую, поэтому первой идет y.
+	for i, want := range []*bc.Transaction{txs[2], txs[0]} {
+		if got := block.Transactions[i]; bc.Base64Encode(got.CurrHash) != bc.Base64Encode(want.CurrHash) {
+			t.Errorf("Template()[%d] has fee %d, want %d", i, got.Fee, want.Fee)
+		}
+	}
+	if fee := pool.EstimateFee(chain); fee != 6 {
+		t.Errorf("EstimateFee() = %d, want 6", fee)
+	}
+}
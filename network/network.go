@@ -1,7 +1,9 @@
 package network
 
 import (
-	"encoding/json"
+	"encoding/binary"
+	"errors"
+	"io"
 	"net"
 	"strings"
 	"time"
@@ -13,12 +15,12 @@ type Package struct {
 }
 
 const (
-	// Гарантирует завершенность данных
-	ENDBYTES = "\000\005\007\001\001\007\005\000"
+	// Пакет: байт версии, опция и длина данных по 8 байт, затем данные
+	VERSION  = 1
+	HEADSIZE = 1 + 8 + 8
 
 	WAITTIME = 5
 	DMAXSIZE = (2 << 20) // (2^20) * 2 = 2MiB
-	BUFFSIZE = (4 << 10) // (2^10) * 4 = 4KiB
 )
 
 type Listener net.Listener
@@ -30,10 +32,10 @@ func Handler(option int, conn Conn, pack *Package, handle func(*Package) string)
 		return false
 	}
 
-	conn.Write([]byte(SerializePackage(&Package{
+	conn.Write(SerializePackage(&Package{
 		Option: option,
 		Data:   handle(pack),
-	}) + ENDBYTES))
+	}))
 	return true
 }
 
@@ -77,7 +79,7 @@ func Send(address string, pack *Package) *Package {
 		return nil
 	}
 	defer conn.Close()
-	conn.Write([]byte(SerializePackage(pack) + ENDBYTES))
+	conn.Write(SerializePackage(pack))
 	var (
 		res = new(Package)
 		ch  = make(chan bool)
@@ -94,43 +96,49 @@ func Send(address string, pack *Package) *Package {
 	return res
 }
 
-func SerializePackage(pack *Package) string {
-	jsonData, err := json.MarshalIndent(*pack, "", "\t")
-	if err != nil {
-		return ""
-	}
-	return string(jsonData)
+func SerializePackage(pack *Package) []byte {
+	data := make([]byte, HEADSIZE, HEADSIZE+len(pack.Data))
+	data[0] = VERSION
+	binary.BigEndian.PutUint64(data[1:9], uint64(pack.Option))
+	binary.BigEndian.PutUint64(data[9:HEADSIZE], uint64(len(pack.Data)))
+	return append(data, pack.Data...)
 }
 
-func DeserializePackage(data string) *Package {
-	var pack Package
-	err := json.Unmarshal([]byte(data), &pack)
-	if err != nil {
+func DeserializePackage(data []byte) *Package {
+	size, err := packageSize(data)
+	if err != nil || uint64(len(data)-HEADSIZE) != size {
 		return nil
 	}
-	return &pack
+	return &Package{
+		Option: int(binary.BigEndian.Uint64(data[1:9])),
+		Data:   string(data[HEADSIZE:]),
+	}
+}
+
+func packageSize(head []byte) (uint64, error) {
+	if len(head) < HEADSIZE || head[0] != VERSION {
+		return 0, errors.New("unknown package version")
+	}
+	size := binary.BigEndian.Uint64(head[9:HEADSIZE])
+	if size > DMAXSIZE-HEADSIZE {
+		return 0, errors.New("package is too large")
+	}
+	return size, nil
 }
 
 func readPackage(conn net.Conn) *Package {
-	var (
-		data   string
-		size   = uint64(0)
-		buffer = make([]byte, BUFFSIZE)
-	)
-	for {
-		length, err := conn.Read(buffer)
-		if err != nil {
-			return nil
-		}
-		size += uint64(length)
-		if size > DMAXSIZE {
-			return nil
-		}
-		data += string(buffer[:length])
-		if strings.Contains(data, ENDBYTES) {
-			data = strings.Split(data, ENDBYTES)[0]
-			break
-		}
+	head := make([]byte, HEADSIZE)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil
+	}
+	size, err := packageSize(head)
+	if err != nil {
+		return nil
+	}
+	data := make([]byte, HEADSIZE+size)
+	copy(data, head)
+	if _, err := io.ReadFull(conn, data[HEADSIZE:]); err != nil {
+		return nil
 	}
 	return DeserializePackage(data)
 }
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	bc "tchain/blockchain"
+	mp "tchain/mempool"
 	nt "tchain/network"
 )
 
@@ -20,6 +22,8 @@ func init() {
 		userLoadStr  = ""
 		chainNewStr  = ""
 		chainLoadStr = ""
+		specStr      = ""
+		memoLimit    = bc.MEMO_LIMIT
 	)
 	var (
 		serveExist     = false
@@ -28,6 +32,7 @@ func init() {
 		userLoadExist  = false
 		chainLoadExist = false
 		chainNewExist  = false
+		specExist      = false
 	)
 
 	for i := 1; i < len(os.Args); i++ {
@@ -42,12 +47,21 @@ func init() {
 		case strings.HasPrefix(arg, "-newchain:"):
 			chainNewStr = strings.Replace(arg, "-newchain:", "", 1)
 			chainNewExist = true
+		case strings.HasPrefix(arg, "-spec:"):
+			specStr = strings.Replace(arg, "-spec:", "", 1)
+			specExist = true
 		case strings.HasPrefix(arg, "-loadaddr:"):
 			addrStr = strings.Replace(arg, "-loadaddr:", "", 1)
 			addrExist = true
 		case strings.HasPrefix(arg, "-newuser:"):
 			userNewStr = strings.Replace(arg, "-newuser:", "", 1)
 			userNewExist = true
+		case strings.HasPrefix(arg, "-memolimit:"):
+			num, err := strconv.Atoi(strings.Replace(arg, "-memolimit:", "", 1))
+			if err != nil || num < 0 || num > bc.MEMO_LIMIT {
+				panic("failed: memo limit")
+			}
+			memoLimit = num
 		case strings.HasPrefix(arg, "-loaduser:"):
 			userLoadStr = strings.Replace(arg, "-loaduser:", "", 1)
 			userLoadExist = true
@@ -74,8 +88,23 @@ func init() {
 		mapaddr[addr] = true
 		Addresses = append(Addresses, addr)
 	}
+	spec := bc.DefaultSpec("")
+	if specExist {
+		spec = bc.LoadSpec(specStr)
+		if spec == nil {
+			panic("failed: load spec")
+		}
+	}
+	if chainLoadExist {
+		Filename = chainLoadStr
+		Chain = chainLoad(chainLoadStr)
+		if Chain == nil {
+			panic("faild 6")
+		}
+		spec = Chain.Spec
+	}
 	if userNewExist {
-		User = userNew(userNewStr)
+		User = userNew(userNewStr, spec.KeySize)
 	}
 	if userLoadExist {
 		User = userLoad(userLoadStr)
@@ -84,32 +113,39 @@ func init() {
 		panic("failed: load user")
 	}
 	if chainNewExist {
+		if !specExist {
+			spec = bc.DefaultSpec(User.Address())
+		}
 		Filename = chainNewStr
-		Chain = chainNew(chainNewStr)
-	}
-	if chainLoadExist {
-		Filename = chainLoadStr
-		Chain = chainNew(chainLoadStr)
+		Chain = chainNew(chainNewStr, spec)
 	}
 	if Chain == nil {
 		panic("faild 6")
 	}
-	Block = bc.NewBlock(User.Address(), Chain.LastHash())
+	Addresses = checkPeers(Addresses, Chain.ID())
+	Pool = mp.NewPool(POOL_LIMIT, POOL_EXPIRY, memoLimit)
 }
 
 func main() {
+	go checkLocked()
 	nt.Listen(Serve, handleServerServe)
 	for {
 		fmt.Scanln()
 	}
 }
 
-func chainNew(filename string) *bc.BlockChain {
-	bc.NewChain(filename, User.Address())
-	return bc.LoadChain(filename)
+func chainNew(filename string, spec *bc.ChainSpec) *bc.BlockChain {
+	if bc.NewChain(filename, spec) != nil {
+		return nil
+	}
+	chain, _ := bc.LoadChain(filename)
+	return chain
 }
 
 func chainLoad(filename string) *bc.BlockChain {
-	chain := bc.LoadChain(filename)
+	chain, err := bc.LoadChain(filename)
+	if err != nil {
+		panic("failed: load chain: " + err.Error())
+	}
 	return chain
 }
@@ -2,26 +2,25 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
-	"encoding/hex"
 	"fmt"
-	"io"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
 	bc "tchain/blockchain"
+	mp "tchain/mempool"
 	nt "tchain/network"
+	"time"
 )
 
 var (
 	Filename     string
 	Serve        string
 	Chain        *bc.BlockChain
-	Block        *bc.Block
+	Pool         *mp.Pool
 	Mutex        sync.Mutex
 	IsMining     bool
-	BreakMininig = make(chan bool)
+	IsSyncing    bool
+	BreakMininig = make(chan bool, 1)
 )
 
 func handleServerServe(conn nt.Conn, pack *nt.Package) {
@@ -30,6 +29,18 @@ func handleServerServe(conn nt.Conn, pack *nt.Package) {
 	nt.Handler(GET_BLOCK, conn, pack, getBlock)
 	nt.Handler(GET_LHASH, conn, pack, getLastHash)
 	nt.Handler(GET_BALANCE, conn, pack, getBalance)
+	nt.Handler(GET_TRNSX, conn, pack, getTransaction)
+	nt.Handler(GET_HISTORY, conn, pack, getHistory)
+	nt.Handler(GET_FORK, conn, pack, getFork)
+	nt.Handler(GET_BLOCKS, conn, pack, getBlocks)
+	nt.Handler(GET_HEADERS, conn, pack, getHeaders)
+	nt.Handler(GET_BODIES, conn, pack, getBodies)
+	nt.Handler(GET_PROOF, conn, pack, getProof)
+	nt.Handler(GET_BPROOF, conn, pack, getBalanceProof)
+	nt.Handler(GET_SPEC, conn, pack, getSpec)
+	nt.Handler(HANDSHAKE, conn, pack, getChainID)
+	nt.Handler(GET_NONCE, conn, pack, getNonce)
+	nt.Handler(GET_FEE, conn, pack, getFee)
 }
 
 func addBlock(pack *nt.Package) string {
@@ -38,26 +49,31 @@ func addBlock(pack *nt.Package) string {
 		return "fail"
 	}
 	block := bc.DeserializeBlock(splited[2])
-	if !block.IsValid(Chain) {
+	Mutex.Lock()
+	lastHash := Chain.LastHash()
+	txs, err := Chain.AcceptBlock(block)
+	if err != nil {
 		currSize := Chain.Size()
-		num, err := strconv.Atoi(splited[1])
-		if err != nil {
+		Mutex.Unlock()
+		num, err2 := strconv.Atoi(splited[1])
+		if err2 != nil {
 			return "fail"
 		}
-		if currSize < uint64(num) {
-			go compareChains(splited[0], uint64(num))
+		if err == bc.ErrUnknownParent && currSize < uint64(num) {
+			go syncChain(splited[0], uint64(num))
 			return "ok"
 		}
 		return "fail"
 	}
-	Mutex.Lock()
-	Chain.AddBlock(block)
-	Block = bc.NewBlock(User.Address(), Chain.LastHash())
+	if bytes.Equal(lastHash, Chain.LastHash()) {
+		Mutex.Unlock()
+		return "ok"
+	}
+	mining := resetPool(txs)
 	Mutex.Unlock()
 
-	if IsMining {
-		BreakMininig <- true
-		IsMining = false
+	if mining {
+		breakMining()
 	}
 
 	return "ok"
@@ -65,35 +81,59 @@ func addBlock(pack *nt.Package) string {
 
 func addTransaction(pack *nt.Package) string {
 	var tx = bc.DeserializeTX(pack.Data)
-	if tx == nil || len(Block.Transactions) == bc.TXS_LIMIT {
+	if tx == nil {
 		return "fail"
 	}
 	Mutex.Lock()
-	err := Block.AddTransaction(Chain, tx)
-	Mutex.Unlock()
-	if err != nil {
+	defer Mutex.Unlock()
+	if Pool.Add(Chain, tx) != nil {
 		return "fail"
 	}
-	if len(Block.Transactions) == bc.TXS_LIMIT {
-		go func() {
-			Mutex.Lock()
-			block := *Block
-			IsMining = true
-			Mutex.Unlock()
-			err := (&block).Accept(Chain, User, BreakMininig)
-			Mutex.Lock()
-			IsMining = false
-			if err == nil && bytes.Equal(block.PrevHash, Block.PrevHash) {
-				Chain.AddBlock(&block)
-				pushBlockToNet(&block)
-			}
-			Block = bc.NewBlock(User.Address(), Chain.LastHash())
-			Mutex.Unlock()
-		}()
-	}
+	startMining()
 	return "ok"
 }
 
+// Вызывается под Mutex. Майнинг начинается, когда в пуле набирается
+// полный блок из действительных транзакций.
+func startMining() {
+	if IsMining || uint64(Pool.Ready(Chain)) < Chain.Spec.TxsLimit {
+		return
+	}
+	IsMining = true
+	go mineBlock()
+}
+
+// Транзакции с блокировкой по времени становятся действительными
+// без новых блоков, поэтому пул периодически проверяется.
+func checkLocked() {
+	for range time.Tick(LOCK_CHECK) {
+		Mutex.Lock()
+		startMining()
+		Mutex.Unlock()
+	}
+}
+
+func mineBlock() {
+	Mutex.Lock()
+	select {
+	case <-BreakMininig:
+	default:
+	}
+	block := Pool.Template(Chain, User.Address())
+	Mutex.Unlock()
+	err := block.Accept(Chain, User, BreakMininig)
+	Mutex.Lock()
+	defer Mutex.Unlock()
+	IsMining = false
+	if err == nil && bytes.Equal(block.PrevHash, Chain.LastHash()) {
+		if _, err := Chain.AcceptBlock(block); err == nil {
+			pushBlockToNet(block)
+		}
+	}
+	Pool.Update(Chain)
+	startMining()
+}
+
 func getBlock(pack *nt.Package) string {
 	num, err := strconv.Atoi(pack.Data)
 	if err != nil {
@@ -106,6 +146,86 @@ func getBlock(pack *nt.Package) string {
 	return ""
 }
 
+func getBlocks(pack *nt.Package) string {
+	start, count, ok := parseRange(pack.Data)
+	if !ok {
+		return ""
+	}
+	var blocks []string
+	for i := start; i < start+count; i++ {
+		block := Chain.Block(i + 1)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, bc.SerializeBlock(block))
+	}
+	return joinLimited(blocks)
+}
+
+func getHeaders(pack *nt.Package) string {
+	start, count, ok := parseRange(pack.Data)
+	if !ok {
+		return ""
+	}
+	var headers []string
+	for i := start; i < start+count; i++ {
+		block := Chain.Block(i + 1)
+		if block == nil {
+			break
+		}
+		headers = append(headers, bc.SerializeHeader(&block.BlockHeader))
+	}
+	return joinLimited(headers)
+}
+
+func getBodies(pack *nt.Package) string {
+	var bodies []string
+	for i, hash := range strings.Split(pack.Data, SEPARATOR) {
+		if i == BLOCKS_LIMIT {
+			break
+		}
+		block, _ := Chain.BlockByHash(bc.Base64Decode(hash))
+		if block == nil {
+			break
+		}
+		bodies = append(bodies, bc.SerializeBody(&block.BlockBody))
+	}
+	return joinLimited(bodies)
+}
+
+func getProof(pack *nt.Package) string {
+	splited := strings.Split(pack.Data, SEPARATOR)
+	if len(splited) != 2 {
+		return ""
+	}
+	proof := Chain.ProveTransaction(bc.Base64Decode(splited[0]), bc.Base64Decode(splited[1]))
+	if proof == nil {
+		return ""
+	}
+	return bc.SerializeProof(proof)
+}
+
+func getBalanceProof(pack *nt.Package) string {
+	proof := Chain.ProveBalance(pack.Data)
+	if proof == nil {
+		return ""
+	}
+	return bc.SerializeBalanceProof(proof)
+}
+
+// Узел другой цепочки получает отказ: он сравнивает ответ со своим
+// идентификатором и не добавляет узел в список.
+func getChainID(pack *nt.Package) string {
+	if pack.Data != bc.Base64Encode(Chain.ID()) {
+		return "fail"
+	}
+	return pack.Data
+}
+
+func getSpec(pack *nt.Package) string {
+	return bc.SerializeSpec(Chain.Spec)
+}
+
 func getLastHash(pack *nt.Package) string {
 	return bc.Base64Encode(Chain.LastHash())
 }
@@ -114,70 +234,195 @@ func getBalance(pack *nt.Package) string {
 	return fmt.Sprintf("%d", Chain.Balance(pack.Data, Chain.Size()))
 }
 
-func compareChains(address string, num uint64) {
-	filename := "temp_" + hex.EncodeToString(bc.GenerateRandomBytes(8))
-	file, err := os.Create(filename)
-	if err != nil {
+// Номер следующей транзакции адреса с учетом ожидающих в пуле.
+func getNonce(pack *nt.Package) string {
+	Mutex.Lock()
+	defer Mutex.Unlock()
+	return fmt.Sprintf("%d", Pool.NextNonce(Chain, pack.Data))
+}
+
+func getFee(pack *nt.Package) string {
+	Mutex.Lock()
+	defer Mutex.Unlock()
+	return fmt.Sprintf("%d", Pool.EstimateFee(Chain))
+}
+
+func getTransaction(pack *nt.Package) string {
+	tx, height := Chain.GetTransaction(bc.Base64Decode(pack.Data))
+	if tx == nil {
+		return ""
+	}
+	return bc.SerializeRecords([]bc.TxRecord{{
+		Height:      height,
+		Transaction: *tx,
+	}})
+}
+
+func getHistory(pack *nt.Package) string {
+	splited := strings.Split(pack.Data, SEPARATOR)
+	if len(splited) != 2 {
+		return ""
+	}
+	page, err := strconv.Atoi(splited[1])
+	if err != nil || page < 0 {
+		return ""
+	}
+	return bc.SerializeRecords(Chain.History(splited[0], uint64(page)))
+}
+
+func getFork(pack *nt.Package) string {
+	var locator [][]byte
+	for _, hash := range strings.Split(pack.Data, SEPARATOR) {
+		locator = append(locator, bc.Base64Decode(hash))
+	}
+	fork := Chain.FindFork(locator)
+	if fork == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", fork)
+}
+
+func syncChain(address string, num uint64) {
+	Mutex.Lock()
+	if IsSyncing {
+		Mutex.Unlock()
 		return
 	}
-	file.Close()
+	IsSyncing = true
+	chainID := Chain.ID()
+	var locator []string
+	for _, hash := range Chain.Locator() {
+		locator = append(locator, bc.Base64Encode(hash))
+	}
+	Mutex.Unlock()
+	mining := false
 	defer func() {
-		os.Remove(filename)
+		Mutex.Lock()
+		IsSyncing = false
+		Mutex.Unlock()
+		if mining {
+			breakMining()
+		}
 	}()
-	res := nt.Send(address, &nt.Package{
-		Option: GET_BLOCK,
-		Data:   fmt.Sprintf("%d", 0),
-	})
-	if res == nil {
+	res := handshake(address, chainID)
+	if res == nil || res.Data != bc.Base64Encode(chainID) {
 		return
 	}
-	genesis := bc.DeserializeBlock(res.Data)
-	if genesis == nil {
+	res = nt.Send(address, &nt.Package{
+		Option: GET_FORK,
+		Data:   strings.Join(locator, SEPARATOR),
+	})
+	if res == nil || res.Data == "" {
 		return
 	}
-	db, err := sql.Open("sqlite3", filename)
+	fork, err := strconv.ParseUint(res.Data, 10, 64)
 	if err != nil {
 		return
 	}
-	defer db.Close()
-	_, err = db.Exec(bc.CREATE_TABLE)
-	chain := &bc.BlockChain{
-		DB: db,
-	}
-	chain.AddBlock(genesis)
-	for i := uint64(1); i < num; i++ {
-		res := nt.Send(address, &nt.Package{
-			Option: GET_BLOCK,
-			Data:   fmt.Sprintf("%d", i),
-		})
-		if res == nil {
+	// Пачки по BLOCKS_LIMIT применяются сразу, поэтому принятые блоки
+	// остаются, даже если узел перестал отвечать до высоты num.
+	for i := fork; i < num; {
+		headers := loadHeaders(address, i, num-i)
+		if len(headers) == 0 {
 			return
 		}
-		block := bc.DeserializeBlock(res.Data)
-		if block == nil {
+		Mutex.Lock()
+		valid := Chain.CheckHeaders(headers)
+		Mutex.Unlock()
+		if !valid {
 			return
 		}
-		if !block.IsValid(chain) {
+		blocks := loadBodies(address, headers)
+		Mutex.Lock()
+		lastHash := Chain.LastHash()
+		txs, err := Chain.AcceptBlocks(blocks)
+		if err == nil && !bytes.Equal(lastHash, Chain.LastHash()) && resetPool(txs) {
+			mining = true
+		}
+		Mutex.Unlock()
+		if err != nil || len(blocks) < len(headers) {
 			return
 		}
-		chain.AddBlock(block)
+		i += uint64(len(blocks))
 	}
-	Mutex.Lock()
-	Chain.DB.Close()
+}
 
-	Chain.DB.Close()
-	os.Remove(Filename)
+// Не больше BLOCKS_LIMIT заголовков от высоты start; загрузка
+// останавливается на заголовке, который не разбирается.
+func loadHeaders(address string, start, count uint64) []*bc.BlockHeader {
+	if count > BLOCKS_LIMIT {
+		count = BLOCKS_LIMIT
+	}
+	res := nt.Send(address, &nt.Package{
+		Option: GET_HEADERS,
+		Data:   fmt.Sprintf("%d%s%d", start, SEPARATOR, count),
+	})
+	if res == nil || res.Data == "" {
+		return nil
+	}
+	var headers []*bc.BlockHeader
+	for _, sheader := range strings.Split(res.Data, SEPARATOR) {
+		header := bc.DeserializeHeader(sheader)
+		if header == nil || uint64(len(headers)) == count {
+			break
+		}
+		headers = append(headers, header)
+	}
+	return headers
+}
 
-	copyFile(filename, Filename)
-	Chain = bc.LoadChain(Filename)
-	Block = bc.NewBlock(User.Address(), Chain.LastHash())
-	Mutex.Unlock()
-	if IsMining {
-		BreakMininig <- true
-		IsMining = false
+// Тела блоков headers по порядку; при первом отказе или ошибке
+// возвращаются уже собранные блоки.
+func loadBodies(address string, headers []*bc.BlockHeader) []*bc.Block {
+	var blocks []*bc.Block
+	for len(blocks) < len(headers) {
+		var hashes []string
+		for _, header := range headers[len(blocks):] {
+			hashes = append(hashes, bc.Base64Encode(header.CurrHash))
+		}
+		res := nt.Send(address, &nt.Package{
+			Option: GET_BODIES,
+			Data:   strings.Join(hashes, SEPARATOR),
+		})
+		if res == nil || res.Data == "" {
+			return blocks
+		}
+		for _, sbody := range strings.Split(res.Data, SEPARATOR) {
+			body := bc.DeserializeBody(sbody)
+			if body == nil || len(blocks) == len(headers) {
+				return blocks
+			}
+			blocks = append(blocks, &bc.Block{
+				BlockHeader: *headers[len(blocks)],
+				BlockBody:   *body,
+			})
+		}
+	}
+	return blocks
+}
+
+// Прерывает текущий майнинг, не блокируясь. Сигнал, оставшийся в буфере
+// после конца раунда, сбрасывается в начале следующего.
+func breakMining() {
+	select {
+	case BreakMininig <- true:
+	default:
 	}
+}
 
-	return
+// Вызывается под Mutex после смены вершины цепочки: возвращает в пул
+// транзакции отключенных блоков и перепроверяет пул. Возвращает true,
+// если текущий майнинг нужно прервать.
+func resetPool(txs []bc.Transaction) bool {
+	Pool.Update(Chain)
+	for i := range txs {
+		Pool.Add(Chain, &txs[i])
+	}
+	if IsMining {
+		return true
+	}
+	startMining()
+	return false
 }
 
 func pushBlockToNet(block *bc.Block) {
@@ -194,26 +439,40 @@ func pushBlockToNet(block *bc.Block) {
 }
 
 func selectBlock(chain *bc.BlockChain, i int) string {
-	var sblock string
-	row := chain.DB.QueryRow("SELECT Block FROM BlockChain WHERE Id=$1", i+1)
-	row.Scan(&sblock)
-	return sblock
+	block := chain.Block(uint64(i + 1))
+	if block == nil {
+		return ""
+	}
+	return bc.SerializeBlock(block)
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+func parseRange(data string) (uint64, uint64, bool) {
+	splited := strings.Split(data, SEPARATOR)
+	if len(splited) != 2 {
+		return 0, 0, false
 	}
-	defer in.Close()
-	out, err := os.Create(dst)
+	start, err := strconv.ParseUint(splited[0], 10, 64)
 	if err != nil {
-		return err
+		return 0, 0, false
 	}
-	defer out.Close()
-	_, err = io.Copy(out, in)
+	count, err := strconv.ParseUint(splited[1], 10, 64)
 	if err != nil {
-		return err
+		return 0, 0, false
+	}
+	if count > BLOCKS_LIMIT {
+		count = BLOCKS_LIMIT
+	}
+	return start, count, true
+}
+
+// Оставляет столько элементов, сколько помещается в пакет размером DMAXSIZE.
+func joinLimited(list []string) string {
+	size := nt.HEADSIZE
+	for i, item := range list {
+		size += len(item) + len(SEPARATOR)
+		if size > nt.DMAXSIZE {
+			return strings.Join(list[:i], SEPARATOR)
+		}
 	}
-	return out.Close()
+	return strings.Join(list, SEPARATOR)
 }
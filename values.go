@@ -1,9 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
+	"time"
 
 	bc "tchain/blockchain"
+	nt "tchain/network"
 )
 
 var (
@@ -12,7 +15,11 @@ var (
 )
 
 const (
-	SEPARATOR = "_SEPARATOR_"
+	SEPARATOR    = "_SEPARATOR_"
+	BLOCKS_LIMIT = 100
+	POOL_LIMIT   = 1000
+	POOL_EXPIRY  = time.Hour
+	LOCK_CHECK   = 10 * time.Second
 )
 
 const (
@@ -21,10 +28,43 @@ const (
 	GET_BLOCK
 	GET_LHASH
 	GET_BALANCE
+	GET_TRNSX
+	GET_HISTORY
+	GET_FORK
+	GET_BLOCKS
+	GET_HEADERS
+	GET_BODIES
+	GET_PROOF
+	GET_BPROOF
+	GET_SPEC
+	HANDSHAKE
+	GET_NONCE
+	GET_FEE
 )
 
-func userNew(filename string) *bc.User {
-	user := bc.NewUser()
+// Отбрасывает узлы, которые отвечают идентификатором другой цепочки.
+// Недоступные узлы остаются в списке.
+func checkPeers(addresses []string, chainID []byte) []string {
+	var peers []string
+	for _, addr := range addresses {
+		if res := handshake(addr, chainID); res != nil && res.Data != bc.Base64Encode(chainID) {
+			fmt.Printf("fail: other chain (%s)\n", addr)
+			continue
+		}
+		peers = append(peers, addr)
+	}
+	return peers
+}
+
+func handshake(address string, chainID []byte) *nt.Package {
+	return nt.Send(address, &nt.Package{
+		Option: HANDSHAKE,
+		Data:   bc.Base64Encode(chainID),
+	})
+}
+
+func userNew(filename string, bits uint64) *bc.User {
+	user := bc.NewUser(bits)
 	if user == nil {
 		return nil
 	}